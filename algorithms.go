@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Algorithms implemented by golang.org/x/crypto/ssh on the client side.
+// These mirror the package's internal supported lists, which are not exported.
+var supportedCiphers = []string{
+	"aes128-ctr", "aes192-ctr", "aes256-ctr",
+	"aes128-gcm@openssh.com", "aes256-gcm@openssh.com",
+	"chacha20-poly1305@openssh.com",
+	"arcfour256", "arcfour128", "arcfour",
+	"aes128-cbc",
+	"3des-cbc",
+}
+
+var supportedKeyExchanges = []string{
+	"curve25519-sha256", "curve25519-sha256@libssh.org",
+	"ecdh-sha2-nistp256", "ecdh-sha2-nistp384", "ecdh-sha2-nistp521",
+	"diffie-hellman-group14-sha256", "diffie-hellman-group16-sha512",
+	"diffie-hellman-group14-sha1", "diffie-hellman-group1-sha1",
+	"diffie-hellman-group-exchange-sha256", "diffie-hellman-group-exchange-sha1",
+}
+
+var supportedMACs = []string{
+	"hmac-sha2-256-etm@openssh.com", "hmac-sha2-512-etm@openssh.com",
+	"hmac-sha2-256", "hmac-sha2-512",
+	"hmac-sha1", "hmac-sha1-96",
+}
+
+// Algorithms that are supported but considered weak. They are allowed so that
+// legacy devices stay reachable, but a warning is logged when they are used.
+var insecureAlgorithms = map[string]bool{
+	"arcfour256":                         true,
+	"arcfour128":                         true,
+	"arcfour":                            true,
+	"aes128-cbc":                         true,
+	"3des-cbc":                           true,
+	"diffie-hellman-group1-sha1":         true,
+	"diffie-hellman-group14-sha1":        true,
+	"diffie-hellman-group-exchange-sha1": true,
+	"hmac-sha1":                          true,
+	"hmac-sha1-96":                       true,
+}
+
+// validateAlgorithms checks a server's custom algorithm lists against the
+// supported sets and warns about insecure choices.
+func validateAlgorithms(serverName string, serverConfig *ServerConfig) error {
+	lists := []struct {
+		option    string
+		names     []string
+		supported []string
+	}{
+		{"ciphers", serverConfig.Ciphers, supportedCiphers},
+		{"kexAlgorithms", serverConfig.KeyExchanges, supportedKeyExchanges},
+		{"macs", serverConfig.MACs, supportedMACs},
+	}
+
+	for _, list := range lists {
+		for _, name := range list.names {
+			if !containsString(list.supported, name) {
+				return fmt.Errorf("unsupported %s entry %q for server %s (supported: %s)",
+					list.option, name, serverName, strings.Join(list.supported, ","))
+			}
+			if insecureAlgorithms[name] {
+				log.Printf("Warning: server %s uses insecure %s entry %s", serverName, list.option, name)
+			}
+		}
+	}
+	return nil
+}
+
+// Bounds of rekeyThreshold. Beyond 64GB even 128-bit block ciphers exceed
+// the 2^32 blocks per key that RFC 4344 recommends.
+const (
+	minRekeyThreshold = 1 << 20
+	maxRekeyThreshold = 64 << 30
+)
+
+// algorithmConfig builds the ssh.Config for a server. Empty lists and a zero
+// rekey threshold keep the library defaults.
+func algorithmConfig(serverConfig *ServerConfig) ssh.Config {
+	return ssh.Config{
+		Ciphers:        serverConfig.Ciphers,
+		KeyExchanges:   serverConfig.KeyExchanges,
+		MACs:           serverConfig.MACs,
+		RekeyThreshold: serverConfig.RekeyThreshold,
+	}
+}
+
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"path"
+	"strings"
+)
+
+// checkTarget applies a SOCKS forward's denyTargets and auditTargets settings
+// to a request for requested (host:port as sent by the client), which
+// resolved to target. It reports whether the request may go ahead.
+func checkTarget(config *ForwardConfig, client net.Addr, command, requested, target string) bool {
+	denied := targetDenied(config.DenyTargets, requested) || (target != requested && targetDenied(config.DenyTargets, target))
+
+	if config.AuditTargets {
+		action := "allow"
+		if denied {
+			action = "deny"
+		}
+		resolved := ""
+		if target != requested {
+			resolved = " resolved=" + target
+		}
+		log.Printf("SOCKS audit forward=%s client=%s command=%s target=%s%s action=%s",
+			config.SectionName, client, command, requested, resolved, action)
+	} else if denied {
+		log.Printf("SOCKS request from %s to %s denied by denyTargets of %s", client, requested, config.SectionName)
+	}
+	return !denied
+}
+
+// targetDenied reports whether address (host:port) matches one of patterns.
+func targetDenied(patterns []string, address string) bool {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return false
+	}
+	for _, pattern := range patterns {
+		if matchTarget(pattern, host, port) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchTarget matches host and port against a denyTargets pattern: a CIDR
+// block, or a host glob such as *.example.com with an optional :port, where
+// * matches any host or port.
+func matchTarget(pattern, host, port string) bool {
+	if _, network, err := net.ParseCIDR(pattern); err == nil {
+		ip := net.ParseIP(host)
+		return ip != nil && network.Contains(ip)
+	}
+
+	hostPattern, portPattern := pattern, "*"
+	if h, p, err := net.SplitHostPort(pattern); err == nil {
+		hostPattern, portPattern = h, p
+	}
+	if portPattern != "*" && portPattern != port {
+		return false
+	}
+	matched, _ := path.Match(strings.ToLower(hostPattern), strings.ToLower(host))
+	return matched
+}
+
+// validateTargetPatterns checks the syntax of denyTargets patterns.
+func validateTargetPatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		if strings.Contains(pattern, "/") {
+			if _, _, err := net.ParseCIDR(pattern); err != nil {
+				return fmt.Errorf("invalid CIDR %q", pattern)
+			}
+			continue
+		}
+		hostPattern := pattern
+		if h, _, err := net.SplitHostPort(pattern); err == nil {
+			hostPattern = h
+		}
+		if _, err := path.Match(hostPattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q", pattern)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// How long an external authCommand may run before the login is rejected
+const authCommandTimeout = 10 * time.Second
+
+// socks5AuthRequired reports whether clients must authenticate with
+// username/password.
+func socks5AuthRequired(config *ForwardConfig) bool {
+	if config.UsernameRouting && config.Socks5Pass != "" {
+		return true
+	}
+	return (config.Socks5User != "" && config.Socks5Pass != "") || config.AuthCommand != "" ||
+		len(config.Socks5Users) > 0 || config.credentials != nil
+}
+
+// verifySocks5Credentials checks the credentials presented by a SOCKS5
+// client against the configured users or the external authCommand. With
+// acceptAnyAuth and no credentials configured, any login is accepted.
+func verifySocks5Credentials(config *ForwardConfig, username, password string) bool {
+	if !socks5AuthRequired(config) && config.AcceptAnyAuth {
+		return true
+	}
+	if config.Socks5User != "" && config.Socks5Pass != "" &&
+		username == config.Socks5User && password == config.Socks5Pass {
+		return true
+	}
+	if stored, ok := config.Socks5Users[username]; ok && checkPassword(stored, password) {
+		return true
+	}
+	if config.credentials != nil {
+		if stored, ok := config.credentials.lookup(username); ok && checkPassword(stored, password) {
+			return true
+		}
+	}
+	if config.AuthCommand != "" {
+		return runAuthCommand(config, username, password)
+	}
+	return false
+}
+
+// verifyRoutingCredentials checks a login to a usernameRouting forward, where
+// the username names the server to tunnel through. It must be a server
+// section; the password must match socks5Pass or pass the authCommand when
+// either is configured.
+func verifyRoutingCredentials(config *ForwardConfig, username, password string) bool {
+	if lookupServer(username) == nil {
+		return false
+	}
+	if config.Socks5Pass != "" {
+		return password == config.Socks5Pass
+	}
+	if config.AuthCommand != "" {
+		return runAuthCommand(config, username, password)
+	}
+	return true
+}
+
+// runAuthCommand runs the forward's authCommand with "username\npassword\n" on
+// stdin. Exit status 0 accepts the login; anything else rejects it.
+func runAuthCommand(config *ForwardConfig, username, password string) bool {
+	args := strings.Fields(config.AuthCommand)
+
+	ctx, cancel := context.WithTimeout(context.Background(), authCommandTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stdin = strings.NewReader(username + "\n" + password + "\n")
+	if err := cmd.Run(); err != nil {
+		if _, ok := err.(*exec.ExitError); !ok {
+			log.Printf("SOCKS5 authCommand for %s failed to run: %v", config.SectionName, err)
+		}
+		return false
+	}
+	return true
+}
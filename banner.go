@@ -0,0 +1,24 @@
+package main
+
+import (
+	"log"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// bannerCallback returns the ssh.BannerCallback for a server. The pre-auth
+// banner often explains why a login is refused or restricted, so it is logged
+// when showBanner (or debug) is enabled and ignored otherwise.
+func bannerCallback(serverName string, serverConfig *ServerConfig) ssh.BannerCallback {
+	if !serverConfig.ShowBanner {
+		return nil
+	}
+	return func(message string) error {
+		message = strings.TrimSpace(message)
+		if message != "" {
+			log.Printf("Banner from %s:\n%s", serverName, message)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// Most targets a forward's circuit breaker tracks; the least recently used
+// entry is dropped beyond this
+const breakerMaxTargets = 1024
+
+// Defaults for breakerWindow and breakerCooldown
+const (
+	breakerDefaultWindow   = time.Minute
+	breakerDefaultCooldown = 30 * time.Second
+)
+
+// targetBreaker fails SOCKS dials fast to targets that keep failing: after
+// failures consecutive failed dials to a host:port within window, further
+// dials to it are refused until cooldown has passed. A successful dial clears
+// the target's record.
+type targetBreaker struct {
+	failures int
+	window   time.Duration
+	cooldown time.Duration
+
+	mutex   sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List // most recently used first
+}
+
+// breakerEntry is the failure record of one target
+type breakerEntry struct {
+	target       string
+	failures     int
+	firstFailure time.Time
+	openUntil    time.Time
+}
+
+func newTargetBreaker(failures int, window, cooldown time.Duration) *targetBreaker {
+	return &targetBreaker{
+		failures: failures,
+		window:   window,
+		cooldown: cooldown,
+		entries:  make(map[string]*list.Element),
+		order:    list.New(),
+	}
+}
+
+// allow reports whether target may be dialed, and otherwise how long it
+// stays blocked.
+func (b *targetBreaker) allow(target string) (bool, time.Duration) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	element, ok := b.entries[target]
+	if !ok {
+		return true, 0
+	}
+	b.order.MoveToFront(element)
+	remaining := time.Until(element.Value.(*breakerEntry).openUntil)
+	if remaining > 0 {
+		return false, remaining
+	}
+	return true, 0
+}
+
+// failure records a failed dial to target and reports whether it opened the
+// circuit.
+func (b *targetBreaker) failure(target string) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	now := time.Now()
+	var entry *breakerEntry
+	if element, ok := b.entries[target]; ok {
+		b.order.MoveToFront(element)
+		entry = element.Value.(*breakerEntry)
+	} else {
+		entry = &breakerEntry{target: target}
+		b.entries[target] = b.order.PushFront(entry)
+		if b.order.Len() > breakerMaxTargets {
+			oldest := b.order.Back()
+			b.order.Remove(oldest)
+			delete(b.entries, oldest.Value.(*breakerEntry).target)
+		}
+	}
+
+	if now.Sub(entry.firstFailure) > b.window {
+		entry.failures = 0
+		entry.firstFailure = now
+	}
+	entry.failures++
+	if entry.failures >= b.failures {
+		entry.openUntil = now.Add(b.cooldown)
+		return true
+	}
+	return false
+}
+
+// success clears target's failure record.
+func (b *targetBreaker) success(target string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if element, ok := b.entries[target]; ok {
+		b.order.Remove(element)
+		delete(b.entries, target)
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// dialChannel opens a direct-tcpip channel to addr through conn, or a
+// direct-streamlocal one when addr is a Unix socket path, giving up
+// when ctx is done. When the
+// forward's active server sets maxChannels, it first waits for a free slot,
+// which is held until the returned connection is closed, so a busy forward
+// queues locally instead of having sshd refuse the channel.
+func dialChannel(ctx context.Context, conn *ssh.Client, config *ForwardConfig, addr string, commonConfig *CommonConfig) (net.Conn, error) {
+	serverConfig := lookupServer(config.ActiveServer())
+	if serverConfig == nil || serverConfig.channelSlots == nil {
+		dialStart := time.Now()
+		channel, err := conn.DialContext(ctx, channelNetwork(addr), addr)
+		if err != nil {
+			return nil, explainChannelError(conn, err)
+		}
+		warnSlowDial(addr+" for "+config.SectionName, dialStart, commonConfig.SlowDialThreshold)
+		return connManager.trackChannel(conn, channel), nil
+	}
+
+	select {
+	case serverConfig.channelSlots <- struct{}{}:
+	default:
+		if commonConfig.Debug {
+			log.Printf("Waiting for a free channel on %s (maxChannels=%d) to dial %s",
+				config.ActiveServer(), serverConfig.MaxChannels, addr)
+		}
+		start := time.Now()
+		select {
+		case serverConfig.channelSlots <- struct{}{}:
+		case <-ctx.Done():
+			return nil, fmt.Errorf("waiting for a free channel: %w", ctx.Err())
+		}
+		if commonConfig.Debug {
+			log.Printf("Got a channel for %s after %s", addr, time.Since(start).Round(time.Millisecond))
+		}
+	}
+
+	release := func() { <-serverConfig.channelSlots }
+	// Waiting for a slot is not part of the dial
+	dialStart := time.Now()
+	channel, err := conn.DialContext(ctx, channelNetwork(addr), addr)
+	if err != nil {
+		release()
+		return nil, explainChannelError(conn, err)
+	}
+	warnSlowDial(addr+" for "+config.SectionName, dialStart, commonConfig.SlowDialThreshold)
+	tracked := connManager.trackChannel(conn, channel)
+	untrack := tracked.release
+	tracked.release = func() {
+		untrack()
+		release()
+	}
+	return tracked, nil
+}
+
+// explainChannelError adds the number of channels open on conn to a channel
+// refused as administratively prohibited, which usually means sshd's
+// MaxSessions was reached rather than that forwarding is disabled.
+func explainChannelError(conn *ssh.Client, err error) error {
+	var openErr *ssh.OpenChannelError
+	if errors.As(err, &openErr) && openErr.Reason == ssh.Prohibited {
+		return fmt.Errorf("%w (%d channels already open on this connection; see maxChannels)", err, connManager.activeChannels(conn))
+	}
+	return err
+}
+
+// isTransportError reports whether a failed channel open means the SSH
+// connection itself is broken. A refusal from the server, such as the target
+// being unreachable, and a dial that was cancelled or timed out are not.
+func isTransportError(err error) bool {
+	var openErr *ssh.OpenChannelError
+	if errors.As(err, &openErr) {
+		return false
+	}
+	return !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+}
+
+// connectionDead reports whether conn, shared by every forward on its server,
+// has really failed after a channel open returned the transport error err.
+// A closed connection shows in err itself; otherwise the server must also
+// leave a keepalive unanswered, so one odd dial error does not drop the
+// sessions of everyone else on the connection.
+func (cm *ConnectionManager) connectionDead(conn *ssh.Client, err error) bool {
+	if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+		return true
+	}
+	var serverName string
+	cm.mutex.RLock()
+	for key, c := range cm.connections {
+		if c == conn {
+			serverName = key
+		}
+	}
+	cm.mutex.RUnlock()
+	return sendKeepalive(serverName, conn) != nil
+}
+
+// limitedConn runs release once when closed, to give back its channel slot
+// and drop it from the connection's active channel count.
+type limitedConn struct {
+	net.Conn
+	release   func()
+	closeOnce sync.Once
+}
+
+func (c *limitedConn) Close() error {
+	err := c.Conn.Close()
+	c.closeOnce.Do(c.release)
+	return err
+}
+
+// CloseWrite keeps half-close working through the wrapper.
+func (c *limitedConn) CloseWrite() error {
+	if halfCloser, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return halfCloser.CloseWrite()
+	}
+	return errors.New("half-close not supported")
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"log"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// pendingDial is a connection attempt other callers can wait for
+type pendingDial struct {
+	done chan struct{} // closed once conn and err are set
+	conn *ssh.Client
+	err  error
+}
+
+// coalesceDial runs dial for serverName unless a dial of it is already in
+// flight, in which case it waits for that one and returns its result. When a
+// link drops, every forward on it asks for a new connection at once; this
+// makes them share one dial instead of hitting a server that just restarted
+// with a burst of them, and lets them all see a failure together rather than
+// repeating the dial one after another.
+func (cm *ConnectionManager) coalesceDial(serverName string, dial func() (*ssh.Client, error)) (*ssh.Client, error) {
+	cm.dialMutex.Lock()
+	if pending, ok := cm.dials[serverName]; ok {
+		cm.dialMutex.Unlock()
+		if serverConfig := lookupServer(poolServerName(serverName)); serverConfig != nil && serverConfig.debug {
+			log.Printf("Waiting for the connection to %s already being made", serverName)
+		}
+		<-pending.done
+		return pending.conn, pending.err
+	}
+	pending := &pendingDial{done: make(chan struct{})}
+	cm.dials[serverName] = pending
+	cm.dialMutex.Unlock()
+
+	pending.conn, pending.err = dial()
+
+	cm.dialMutex.Lock()
+	delete(cm.dials, serverName)
+	cm.dialMutex.Unlock()
+	close(pending.done)
+	return pending.conn, pending.err
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// compressMagic opens a compressed relay. It is sent by the dialing side and
+// echoed by the accepting side; its first byte can't start a SOCKS request,
+// so plain clients are told apart from the first byte.
+var compressMagic = []byte{0xC5, 'S', 'P', 'F', 'Z', 1}
+
+// Time the accepting side has to echo the magic
+const compressHandshakeTimeout = 5 * time.Second
+
+// negotiateCompression offers compression on a freshly dialed conn. When the
+// peer echoes the magic, it returns conn wrapped in deflate; otherwise it
+// closes conn and returns a plain connection from redial. Only a reply other
+// than the magic shows the target doesn't support compression, which is then
+// remembered; after a timeout or I/O error the next connection tries again.
+func negotiateCompression(config *ForwardConfig, conn net.Conn, redial func() (net.Conn, error)) (net.Conn, error) {
+	if config.compressUnsupported.Load() {
+		return conn, nil
+	}
+
+	reply := make([]byte, len(compressMagic))
+	var n int
+	_, err := conn.Write(compressMagic)
+	if err == nil {
+		// SSH channels have no read deadline, so give up by closing conn
+		timer := time.AfterFunc(compressHandshakeTimeout, func() { conn.Close() })
+		n, err = io.ReadFull(conn, reply)
+		if !timer.Stop() {
+			err = fmt.Errorf("no reply to compression handshake")
+		}
+	}
+	if err == nil && bytes.Equal(reply, compressMagic) {
+		return newCompressedConn(conn), nil
+	}
+
+	conn.Close()
+	if n > 0 && !bytes.Equal(reply[:n], compressMagic[:n]) {
+		if !config.compressUnsupported.Swap(true) {
+			log.Printf("Peer of %s does not support compressRelay, relaying uncompressed", config.SectionName)
+		}
+	} else {
+		log.Printf("Compression handshake with peer of %s failed (%v), relaying this connection uncompressed", config.SectionName, err)
+	}
+	return redial()
+}
+
+// acceptCompression checks whether the client of an accepted conn opens with
+// the compression magic. If so it echoes it and returns the conn wrapped in
+// deflate, otherwise the conn with the peeked bytes left to read.
+func acceptCompression(conn net.Conn) (net.Conn, error) {
+	reader := bufio.NewReader(conn)
+	buffered := &bufferedConn{Conn: conn, reader: reader}
+
+	first, err := reader.Peek(1)
+	if err != nil || first[0] != compressMagic[0] {
+		return buffered, nil
+	}
+	magic, err := reader.Peek(len(compressMagic))
+	if err != nil || !bytes.Equal(magic, compressMagic) {
+		return nil, fmt.Errorf("invalid compression handshake")
+	}
+	reader.Discard(len(compressMagic))
+	if _, err := conn.Write(compressMagic); err != nil {
+		return nil, fmt.Errorf("failed to confirm compression: %v", err)
+	}
+	return newCompressedConn(buffered), nil
+}
+
+// compressedConn deflates what is written and inflates what is read. Every
+// write is flushed so interactive traffic isn't held back; data that doesn't
+// compress is sent in stored blocks, costing a few bytes per write.
+type compressedConn struct {
+	net.Conn
+	reader io.ReadCloser
+
+	writeMutex sync.Mutex
+	writer     *flate.Writer
+}
+
+func newCompressedConn(conn net.Conn) *compressedConn {
+	writer, _ := flate.NewWriter(conn, flate.BestSpeed)
+	return &compressedConn{Conn: conn, reader: flate.NewReader(conn), writer: writer}
+}
+
+func (c *compressedConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
+func (c *compressedConn) Write(p []byte) (int, error) {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+
+	if _, err := c.writer.Write(p); err != nil {
+		return 0, err
+	}
+	if err := c.writer.Flush(); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// CloseWrite ends the compressed stream, then half-closes the connection.
+func (c *compressedConn) CloseWrite() error {
+	c.writeMutex.Lock()
+	err := c.writer.Close()
+	c.writeMutex.Unlock()
+	if err != nil {
+		return err
+	}
+	if halfCloser, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return halfCloser.CloseWrite()
+	}
+	return fmt.Errorf("half-close not supported")
+}
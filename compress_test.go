@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"net"
+	"testing"
+)
+
+// dialLoopback returns both ends of a loopback TCP connection.
+func dialLoopback(t testing.TB) (client, server net.Conn) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	client, err = net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err = listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client, server
+}
+
+// Incompressible data must arrive unchanged in both directions, including
+// the end of each stream on half-close.
+func TestCompressedRelayRoundTrip(t *testing.T) {
+	client, server := dialLoopback(t)
+	defer client.Close()
+	defer server.Close()
+
+	up := make([]byte, 1<<20)
+	down := make([]byte, 1<<20)
+	rand.Read(up)
+	rand.Read(down)
+
+	type result struct {
+		data []byte
+		err  error
+	}
+	accepted := make(chan result, 1)
+	go func() {
+		conn, err := acceptCompression(server)
+		if err != nil {
+			accepted <- result{err: err}
+			return
+		}
+		go func() {
+			conn.Write(down)
+			conn.(*compressedConn).CloseWrite()
+		}()
+		data, err := io.ReadAll(conn)
+		accepted <- result{data, err}
+	}()
+
+	config := &ForwardConfig{SectionName: "test"}
+	conn, err := negotiateCompression(config, client, func() (net.Conn, error) {
+		t.Error("redialed although the peer accepts compression")
+		return nil, io.EOF
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	compressed, ok := conn.(*compressedConn)
+	if !ok {
+		t.Fatalf("negotiated %T, want *compressedConn", conn)
+	}
+	go func() {
+		compressed.Write(up)
+		compressed.CloseWrite()
+	}()
+	received, err := io.ReadAll(compressed)
+	if err != nil || !bytes.Equal(received, down) {
+		t.Errorf("client read %d bytes (%v), want the %d sent", len(received), err, len(down))
+	}
+
+	sent := <-accepted
+	if sent.err != nil || !bytes.Equal(sent.data, up) {
+		t.Errorf("server read %d bytes (%v), want the %d sent", len(sent.data), sent.err, len(up))
+	}
+}
+
+// Only a peer answering with something else than the magic is remembered as
+// not supporting compression; a connection failing in the handshake is not.
+func TestNegotiateCompressionFallback(t *testing.T) {
+	tests := []struct {
+		name   string
+		answer func(net.Conn)
+		latch  bool
+	}{
+		{
+			name:   "plain answer",
+			answer: func(conn net.Conn) { conn.Write([]byte("HTTP/1.0 400 Bad Request\r\n\r\n")) },
+			latch:  true,
+		},
+		{
+			name:   "closed without answer",
+			answer: func(conn net.Conn) { conn.Close() },
+			latch:  false,
+		},
+	}
+	for _, tt := range tests {
+		client, server := dialLoopback(t)
+		go func() {
+			io.ReadFull(server, make([]byte, len(compressMagic)))
+			tt.answer(server)
+		}()
+
+		config := &ForwardConfig{SectionName: "test"}
+		redialed := false
+		conn, err := negotiateCompression(config, client, func() (net.Conn, error) {
+			redialed = true
+			plain, peer := dialLoopback(t)
+			peer.Close()
+			return plain, nil
+		})
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if _, ok := conn.(*compressedConn); ok || !redialed {
+			t.Errorf("%s: got %T, redialed %v; want a plain redial", tt.name, conn, redialed)
+		}
+		if config.compressUnsupported.Load() != tt.latch {
+			t.Errorf("%s: compressUnsupported = %v, want %v", tt.name, !tt.latch, tt.latch)
+		}
+		conn.Close()
+		server.Close()
+	}
+}
@@ -0,0 +1,914 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"gopkg.in/ini.v1"
+)
+
+type ServerConfig struct {
+	Server   string
+	User     string
+	Password string
+	Port     string
+	// Private key file used before the password, when set
+	IdentityFile string
+	// Authentication methods to try, in order (key, agent, password)
+	AuthOrder []string
+	// Ask for the password on the terminal at startup when none is set
+	PromptPassword bool
+	// Custom SSH algorithms for legacy devices (empty uses library defaults)
+	Ciphers      []string
+	KeyExchanges []string
+	MACs         []string
+	// Run the keepalive monitor on the connection (default true)
+	Monitor bool
+	// Consecutive unanswered keepalives before the connection is closed
+	KeepaliveFailures int
+	// Global request name sent as keepalive, or "session" to open a session
+	KeepaliveRequest string
+	// Log the server's pre-auth banner (defaults to the debug setting)
+	ShowBanner bool
+	// Common debug setting, for logging done by the connection monitor
+	debug bool
+	// Common slowDialThreshold, for warnings about slow SSH dials
+	slowDial time.Duration
+	// Common warnOldServers, for warnings about server software
+	warnOld bool
+	// Check the remote account matches User after the first connection
+	VerifyUser bool
+	verifyOnce *sync.Once
+	// Address family tried first when the host has several addresses
+	PreferAddressFamily string
+	// Only address family used to reach the server (empty = either)
+	IPFamily string
+	// Jump hosts to connect through, in order (OpenSSH ProxyJump syntax)
+	ProxyJump []jumpHost
+	// Opens the underlying connection to the server (or its first jump host)
+	// in place of a plain TCP dial, e.g. to run SSH over another transport
+	DialFunc func(network, address string) (net.Conn, error)
+	// WebSocket URL of the websocket transport, which DialFunc dials
+	wsURL string
+	// Number of parallel connections to the server (default 1)
+	PoolSize int
+	poolNext uint32
+	// Replace the connection once idle after this long (0 = never)
+	MaxLifetime time.Duration
+	// Retry all forwards of the server as soon as it is connected again
+	RecoverTogether bool
+	// Maximum concurrent channels on the connection (0 = unlimited)
+	MaxChannels  int
+	channelSlots chan struct{}
+	// Bytes after which session keys are renegotiated (0 = cipher default)
+	RekeyThreshold uint64
+}
+
+type CommonConfig struct {
+	Debug         bool
+	PidFile       string
+	ControlListen string
+	// Unix socket streaming connection events as JSON lines
+	EventSocket string
+	// Log relay errors other than normal closes even without debug
+	LogTransferErrors bool
+	// Give each accepted connection a UUID prefixing its log lines
+	ConnectionIDs bool
+	// Time zone of forward schedules (default: local time)
+	Location *time.Location
+	// Default SOCKS5 credentials for forwards that set none
+	Socks5User string
+	Socks5Pass string
+	// Warn about successful dials slower than this (0 = off)
+	SlowDialThreshold time.Duration
+	// Warn about servers running software with known problems
+	WarnOldServers bool
+	// Servers and forwards fetched from a URL, re-polled every
+	// ConfigPollInterval (0 = only on start and reload)
+	ConfigURL          string
+	ConfigTokenEnv     string
+	ConfigPollInterval time.Duration
+	// Log each relayed connection's throughput this often (0 = off)
+	ThroughputInterval time.Duration
+}
+
+// liveCommon holds the [common] settings in effect. A reload publishes a new
+// CommonConfig rather than changing the one running connections read, and
+// listeners take a snapshot for each connection they accept.
+var liveCommon atomic.Pointer[CommonConfig]
+
+// currentCommon returns the [common] settings in effect.
+func currentCommon() *CommonConfig {
+	return liveCommon.Load()
+}
+
+type ForwardConfig struct {
+	SectionName string // Original section name from config.ini
+	ServerName  string // Server value as configured, may list several servers
+	ServerNames []string
+	RemoteIP    string
+	RemotePort  string
+	// Ports of a remote forward whose remotePort is a list or range
+	RemotePorts []string
+	LocalIP     string
+	LocalPort   string
+	Direction   string
+	SSHConfig   *ServerConfig
+	// Name/value pairs for grouping the forward in metrics, status and logs
+	Labels map[string]string
+	// Unix socket on the SSH server a local forward connects to, in place of
+	// remoteIP:remotePort
+	RemoteSocket string
+	// Unix sockets on the SSH server a socks5 forward may connect to
+	Socks5UnixSockets []string
+	// SOCKS5 authentication
+	Socks5User string
+	Socks5Pass string
+	// Further SOCKS5 logins, inline and from an htpasswd-style file
+	Socks5Users     map[string]string
+	Socks5UsersFile string
+	credentials     *credentialFile
+	// External program verifying SOCKS5 credentials
+	AuthCommand string
+	// Accept clients offering only username/password when no auth is configured
+	AcceptAnyAuth bool
+	// Treat the SOCKS5 username as the name of the server to tunnel through
+	UsernameRouting bool
+	// Log a summary line for every SOCKS5 connection
+	AccessLog bool
+	// Reply to a SOCKS CONNECT once the target is dialed (default), or
+	// before, saving a round trip but reporting dial failures as a close
+	ReplyAfterConnect bool
+	// Log every requested target when it is requested, and refuse matching ones
+	AuditTargets bool
+	DenyTargets  []string
+	// Source address for reverse-socks5 outbound dials
+	OutboundIP    string
+	DialInterface string
+	// Source addresses rotated through for reverse-socks5 outbound dials
+	EgressIPs  []net.IP
+	egressNext uint32
+	// Only address family used for reverse-socks5 outbound dials and lookups
+	IPFamily string
+	// Server whose SSH connection carries reverse-socks5 outbound dials
+	EgressServer string
+	// Where reverse-socks5 outbound dials leave from: "local" or "server"
+	ReverseEgress string
+	// DSCP marking of the forward's local sockets (0 = unchanged)
+	DSCP int
+	// Bind the local listener with SO_REUSEPORT
+	ReusePort bool
+	// Ports tried in turn when localPort is taken (nil = localPort only)
+	LocalPortRange *portRange
+	// Goroutines handling accepted connections (0 = one per connection), the
+	// connections that may wait for them and what happens when that is full
+	WorkerPoolSize  int
+	WorkerQueueSize int
+	WorkerOverflow  string
+	// Client networks allowed to connect to the local listener (empty = any)
+	AllowSource []*net.IPNet
+	// New connections per second admitted by the local listener (0 = any),
+	// the burst allowed above it and what happens to excess connections
+	MaxConnRate    float64
+	ConnRateBurst  int
+	ConnRateExcess string
+	// TLS, optionally with client certificates, on the socks5 listener
+	TLSCert     string
+	TLSKey      string
+	TLSClientCA string
+	tlsConfig   *tls.Config
+	// Per-attempt timeout and extra attempts for SOCKS5 target dials
+	DialTimeout time.Duration
+	DialRetries int
+	// Circuit breaker for failing SOCKS5 targets (BreakerFailures 0 = off)
+	BreakerFailures int
+	BreakerWindow   time.Duration
+	BreakerCooldown time.Duration
+	breaker         *targetBreaker
+	// Size of the copy buffer for each direction of a relay (0 = io.Copy's)
+	ChannelBufferSize int
+	// Deflate the tunneled stream when the peer is spf with compressRelay too
+	CompressRelay       bool
+	compressUnsupported atomic.Bool
+	// Commands run on the server before the forward starts and after it stops
+	PreConnectCommand  string
+	PostConnectCommand string
+	// Periodic end-to-end probe of the forward's listener (0 = off)
+	HealthCheck       time.Duration
+	HealthCheckTarget string
+	HealthCheckSend   string
+	health            healthState
+	// Windows in which the forward runs (nil = always)
+	Schedule *schedule
+	// Targets of an sni-route forward by TLS server name
+	SNIRoutes []sniRoute
+	// DNS resolution for SOCKS5 domain targets: "local" or "remote"
+	Resolve   string
+	DNSServer string
+
+	// Hash of the section and inherited settings, compared on reload
+	fingerprint string
+
+	// Server and connection currently used by the forward (failover state)
+	activeServer string
+	activeConn   *ssh.Client
+	// Address the forward's listener is bound to, with the actual port when
+	// localPort or remotePort is 0
+	listenAddr  string
+	activeMutex sync.Mutex
+}
+
+// Config holds everything parsed from config.ini
+type Config struct {
+	Common   *CommonConfig
+	Servers  map[string]*ServerConfig
+	Forwards []*ForwardConfig
+}
+
+// findConfigFile returns the config file to load. An explicit -config flag or
+// SPF_CONFIG wins; otherwise the first existing file from ./config.ini,
+// $XDG_CONFIG_HOME/spf/config.ini and /etc/spf/config.ini is used.
+func findConfigFile(flagPath string) string {
+	if flagPath != "" {
+		return flagPath
+	}
+	if envPath := os.Getenv("SPF_CONFIG"); envPath != "" {
+		return envPath
+	}
+
+	candidates := []string{"config.ini"}
+	configHome := os.Getenv("XDG_CONFIG_HOME")
+	if configHome == "" {
+		if home, err := os.UserHomeDir(); err == nil {
+			configHome = filepath.Join(home, ".config")
+		}
+	}
+	if configHome != "" {
+		candidates = append(candidates, filepath.Join(configHome, "spf", "config.ini"))
+	}
+	candidates = append(candidates, "/etc/spf/config.ini")
+
+	for _, candidate := range candidates {
+		if _, err := os.Stat(candidate); err == nil {
+			return candidate
+		}
+	}
+	// Nothing found, report the error against the default location
+	return "config.ini"
+}
+
+// loadConfig parses the config file and links each forward to its server.
+func loadConfig(path string) (*Config, error) {
+	cfg, err := ini.Load(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := mergeIncludes(cfg, path); err != nil {
+		return nil, err
+	}
+	if err := mergeRemoteConfig(cfg); err != nil {
+		return nil, err
+	}
+
+	config := &Config{
+		Common:  &CommonConfig{Location: time.Local, LogTransferErrors: true},
+		Servers: make(map[string]*ServerConfig),
+	}
+
+	// Parse common configuration
+	if cfg.HasSection("common") {
+		commonSection := cfg.Section("common")
+		config.Common.Debug = commonSection.Key("debug").MustBool(false)
+		config.Common.PidFile = commonSection.Key("pidFile").String()
+		config.Common.ControlListen = commonSection.Key("controlListen").String()
+		config.Common.EventSocket = commonSection.Key("eventSocket").String()
+		config.Common.LogTransferErrors = commonSection.Key("logTransferErrors").MustBool(true)
+		config.Common.ConnectionIDs = commonSection.Key("connectionIDs").MustBool(false)
+		if timezone := commonSection.Key("timezone").String(); timezone != "" {
+			if config.Common.Location, err = time.LoadLocation(timezone); err != nil {
+				return nil, fmt.Errorf("invalid timezone %q: %v", timezone, err)
+			}
+		}
+		config.Common.Socks5User = commonSection.Key("socks5User").String()
+		config.Common.Socks5Pass = commonSection.Key("socks5Pass").String()
+		config.Common.WarnOldServers = commonSection.Key("warnOldServers").MustBool(false)
+		config.Common.ConfigURL = commonSection.Key("configURL").String()
+		config.Common.ConfigTokenEnv = commonSection.Key("configTokenEnv").MustString(defaultConfigTokenEnv)
+		if value := commonSection.Key("configPollInterval").String(); value != "" {
+			interval, err := time.ParseDuration(value)
+			if err != nil || interval < 0 {
+				return nil, fmt.Errorf("invalid configPollInterval %q (expected a duration like 5m)", value)
+			}
+			config.Common.ConfigPollInterval = interval
+		}
+		if value := commonSection.Key("slowDialThreshold").String(); value != "" {
+			threshold, err := time.ParseDuration(value)
+			if err != nil || threshold < 0 {
+				return nil, fmt.Errorf("invalid slowDialThreshold %q (expected a duration like 2s)", value)
+			}
+			config.Common.SlowDialThreshold = threshold
+		}
+		if value := commonSection.Key("throughputInterval").String(); value != "" {
+			interval, err := time.ParseDuration(value)
+			if err != nil || (interval != 0 && interval < minThroughputInterval) {
+				return nil, fmt.Errorf("invalid throughputInterval %q (expected a duration of at least 1s, like 5s)", value)
+			}
+			config.Common.ThroughputInterval = interval
+		}
+	}
+
+	for _, section := range cfg.Sections() {
+		if section.Name() == "DEFAULT" || section.Name() == "common" {
+			continue
+		}
+		// The fingerprint for reload is taken before parsing, which adds the
+		// keys it reads with their defaults to the section. Inherited
+		// [common] values count as a forward's own
+		forwardFingerprint := sectionFingerprint(section,
+			config.Common.Socks5User, config.Common.Socks5Pass, config.Common.Location.String())
+
+		isServer := section.HasKey("user") && (section.HasKey("password") || section.HasKey("identityFile") ||
+			section.HasKey("authOrder") || section.HasKey("promptPassword"))
+		if isServer || section.HasKey("sshConfigHost") {
+			serverConfig := &ServerConfig{
+				Server:       section.Key("server").String(),
+				User:         section.Key("user").String(),
+				Password:     section.Key("password").String(),
+				Port:         section.Key("port").String(),
+				IdentityFile: section.Key("identityFile").String(),
+				AuthOrder:    splitList(section.Key("authOrder").String()),
+				Ciphers:      splitList(section.Key("ciphers").String()),
+				KeyExchanges: splitList(section.Key("kexAlgorithms").String()),
+				MACs:         splitList(section.Key("macs").String()),
+				MaxChannels:  section.Key("maxChannels").MustInt(0),
+				ShowBanner:   section.Key("showBanner").MustBool(config.Common.Debug),
+				debug:        config.Common.Debug,
+				slowDial:     config.Common.SlowDialThreshold,
+				warnOld:      config.Common.WarnOldServers,
+				Monitor:      section.Key("monitor").MustBool(true),
+				VerifyUser:   section.Key("verifyUser").MustBool(false),
+				PoolSize:     section.Key("connectionPoolSize").MustInt(1),
+			}
+			if value := section.Key("maxConnectionLifetime").String(); value != "" {
+				lifetime, err := time.ParseDuration(value)
+				if err != nil || lifetime <= 0 {
+					return nil, fmt.Errorf("invalid maxConnectionLifetime %q for %s (expected a duration like 12h)", value, section.Name())
+				}
+				serverConfig.MaxLifetime = lifetime
+			}
+			switch serverConfig.PreferAddressFamily = section.Key("preferAddressFamily").String(); serverConfig.PreferAddressFamily {
+			case "", familyIPv4, familyIPv6:
+			default:
+				return nil, fmt.Errorf("invalid preferAddressFamily %q for %s (expected ipv4 or ipv6)", serverConfig.PreferAddressFamily, section.Name())
+			}
+			if serverConfig.IPFamily, err = parseIPFamily(section.Key("ipFamily").String()); err != nil {
+				return nil, fmt.Errorf("%v for %s", err, section.Name())
+			}
+			if serverConfig.ProxyJump, err = parseProxyJump(section.Key("proxyJump").String()); err != nil {
+				return nil, fmt.Errorf("invalid server configuration for %s: %v", section.Name(), err)
+			}
+			// Values from config.ini win over the ssh config entry
+			if alias := section.Key("sshConfigHost").String(); alias != "" {
+				if err := applySSHConfigHost(serverConfig, alias); err != nil {
+					return nil, fmt.Errorf("invalid server configuration for %s: %v", section.Name(), err)
+				}
+			}
+			if serverConfig.Port == "" {
+				serverConfig.Port = "22" // Default SSH port
+			}
+			if serverConfig.Port, err = normalizePort(serverConfig.Port); err != nil {
+				return nil, fmt.Errorf("invalid port for server %s: %v", section.Name(), err)
+			}
+			if err := applyTransport(serverConfig, section.Key("transport").String(), section.Key("wsURL").String()); err != nil {
+				return nil, fmt.Errorf("invalid server configuration for %s: %v", section.Name(), err)
+			}
+			if serverConfig.PromptPassword = section.Key("promptPassword").MustBool(false); serverConfig.PromptPassword && !promptPasswordSupported {
+				return nil, fmt.Errorf("promptPassword for %s is not supported on Windows", section.Name())
+			}
+			if err := validateAuthOrder(serverConfig.AuthOrder); err != nil {
+				return nil, fmt.Errorf("invalid server configuration for %s: %v", section.Name(), err)
+			}
+			if serverConfig.PoolSize < 1 {
+				return nil, fmt.Errorf("invalid connectionPoolSize %d for server %s", serverConfig.PoolSize, section.Name())
+			}
+			serverConfig.RecoverTogether = section.Key("recoverTogether").MustBool(false)
+			serverConfig.KeepaliveFailures = section.Key("keepaliveFailures").MustInt(defaultKeepaliveFailures)
+			if serverConfig.KeepaliveFailures < 1 {
+				return nil, fmt.Errorf("invalid keepaliveFailures %d for server %s", serverConfig.KeepaliveFailures, section.Name())
+			}
+			serverConfig.KeepaliveRequest = section.Key("keepaliveRequest").MustString(defaultKeepaliveRequest)
+			if serverConfig.MaxChannels < 0 {
+				return nil, fmt.Errorf("invalid maxChannels %d for server %s", serverConfig.MaxChannels, section.Name())
+			}
+			serverConfig.verifyOnce = new(sync.Once)
+			if serverConfig.MaxChannels > 0 {
+				serverConfig.channelSlots = make(chan struct{}, serverConfig.MaxChannels)
+			}
+			if value := section.Key("rekeyThreshold").String(); value != "" {
+				threshold, err := parseByteSize(value)
+				if err != nil || threshold < minRekeyThreshold || threshold > maxRekeyThreshold {
+					return nil, fmt.Errorf("invalid rekeyThreshold %q for server %s (expected 1MB to 64GB)", value, section.Name())
+				}
+				serverConfig.RekeyThreshold = uint64(threshold)
+			}
+			if err := validateAlgorithms(section.Name(), serverConfig); err != nil {
+				return nil, fmt.Errorf("invalid server configuration: %v", err)
+			}
+			config.Servers[section.Name()] = serverConfig
+		} else if section.HasKey("server") && section.HasKey("direction") {
+			// Checked before any Key call, which adds a missing key to the section
+			ownCredentials := section.HasKey("socks5User") || section.HasKey("socks5Pass") ||
+				section.HasKey("socks5Users") || section.HasKey("socks5UsersFile")
+			forwardConfig := &ForwardConfig{
+				SectionName:        section.Name(),
+				ServerName:         section.Key("server").String(),
+				ServerNames:        splitList(section.Key("server").String()),
+				RemoteIP:           section.Key("remoteIP").String(),
+				RemotePort:         section.Key("remotePort").String(),
+				LocalIP:            section.Key("localIP").String(),
+				LocalPort:          section.Key("localPort").String(),
+				Direction:          section.Key("direction").String(),
+				Socks5User:         section.Key("socks5User").String(),
+				Socks5Pass:         section.Key("socks5Pass").String(),
+				Socks5UsersFile:    section.Key("socks5UsersFile").String(),
+				AuthCommand:        section.Key("authCommand").String(),
+				AcceptAnyAuth:      section.Key("acceptAnyAuth").MustBool(false),
+				AccessLog:          section.Key("accessLog").MustBool(false),
+				UsernameRouting:    section.Key("usernameRouting").MustBool(false),
+				CompressRelay:      section.Key("compressRelay").MustBool(false),
+				PreConnectCommand:  section.Key("preConnectCommand").String(),
+				PostConnectCommand: section.Key("postConnectCommand").String(),
+				AuditTargets:       section.Key("auditTargets").MustBool(false),
+				DenyTargets:        splitList(section.Key("denyTargets").String()),
+				OutboundIP:         section.Key("outboundIP").String(),
+				DialInterface:      section.Key("dialInterface").String(),
+				EgressServer:       section.Key("egressServer").String(),
+				ReverseEgress:      section.Key("reverseEgress").String(),
+				Resolve:            section.Key("resolve").String(),
+				DNSServer:          section.Key("dnsServer").String(),
+				TLSCert:            section.Key("tlsCert").String(),
+				TLSKey:             section.Key("tlsKey").String(),
+				TLSClientCA:        section.Key("tlsClientCA").String(),
+			}
+			// Inherit the [common] SOCKS5 credentials unless the forward sets its own
+			isSocks5 := forwardConfig.Direction == "socks5" || forwardConfig.Direction == "reverse-socks5"
+			if isSocks5 && !ownCredentials {
+				forwardConfig.Socks5User = config.Common.Socks5User
+				forwardConfig.Socks5Pass = config.Common.Socks5Pass
+			}
+			// Like ssh -R, remote listeners bind the server's loopback unless told otherwise
+			if forwardConfig.RemoteIP == "" && (forwardConfig.Direction == "remote" || forwardConfig.Direction == "reverse-socks5") {
+				forwardConfig.RemoteIP = "localhost"
+			}
+			// x/crypto/ssh only sends IP addresses, so the * wildcard of ssh -R
+			// becomes every IPv4 interface
+			if forwardConfig.RemoteIP == "*" && (forwardConfig.Direction == "remote" || forwardConfig.Direction == "reverse-socks5") {
+				forwardConfig.RemoteIP = "0.0.0.0"
+			}
+			if err := parseRemotePorts(forwardConfig); err != nil {
+				return nil, err
+			}
+			if err := normalizeForwardPorts(forwardConfig); err != nil {
+				return nil, err
+			}
+			if value := section.Key("schedule").String(); value != "" {
+				if forwardConfig.Schedule, err = parseSchedule(value); err != nil {
+					return nil, fmt.Errorf("invalid schedule for %s: %v", section.Name(), err)
+				}
+			}
+			if err := parseAllowSource(forwardConfig, section.Key("allowSource").String()); err != nil {
+				return nil, err
+			}
+			if err := loadListenerTLS(forwardConfig); err != nil {
+				return nil, err
+			}
+			if err := parseDialOptions(forwardConfig, section); err != nil {
+				return nil, err
+			}
+			if err := parseUnixSockets(forwardConfig, section); err != nil {
+				return nil, err
+			}
+			if err := validateResolve(forwardConfig); err != nil {
+				return nil, err
+			}
+			if forwardConfig.SNIRoutes, err = parseSNIRoutes(section.Key("sniRoutes").String()); err != nil {
+				return nil, fmt.Errorf("invalid sniRoutes for %s: %v", section.Name(), err)
+			}
+			if err := validateTargetPatterns(forwardConfig.DenyTargets); err != nil {
+				return nil, fmt.Errorf("invalid denyTargets for %s: %v", section.Name(), err)
+			}
+			if value := section.Key("dscp").String(); value != "" {
+				if forwardConfig.DSCP, err = parseDSCP(value); err != nil {
+					return nil, fmt.Errorf("%v for %s", err, section.Name())
+				}
+				if !dscpSupported {
+					log.Printf("Warning: dscp is only supported on Linux, ignoring it for %s", section.Name())
+				}
+			}
+			if forwardConfig.ReusePort = section.Key("reusePort").MustBool(false); forwardConfig.ReusePort {
+				if forwardConfig.Direction == "remote" || forwardConfig.Direction == "reverse-socks5" {
+					return nil, fmt.Errorf("reusePort only applies to forwards with a local listener in %s", section.Name())
+				}
+				if !reusePortSupported {
+					log.Printf("Warning: reusePort is only supported on Linux, ignoring it for %s", section.Name())
+				}
+			}
+			if err := parseLocalPortRange(forwardConfig, section.Key("localPortRange").String()); err != nil {
+				return nil, err
+			}
+			if err := parseWorkerPool(forwardConfig, section); err != nil {
+				return nil, err
+			}
+			if err := parseConnRate(forwardConfig, section); err != nil {
+				return nil, err
+			}
+			if err := parseSocks5Users(forwardConfig, section); err != nil {
+				return nil, err
+			}
+			if section.HasKey("replyAfterConnect") && !isSocks5 {
+				return nil, fmt.Errorf("replyAfterConnect only applies to socks5 and reverse-socks5 forwards in %s", section.Name())
+			}
+			forwardConfig.ReplyAfterConnect = section.Key("replyAfterConnect").MustBool(true)
+			if forwardConfig.Labels, err = parseLabels(section.Key("labels").String()); err != nil {
+				return nil, fmt.Errorf("%v in %s", err, section.Name())
+			}
+			if err := parseHealthCheck(forwardConfig, section); err != nil {
+				return nil, err
+			}
+			if forwardConfig.UsernameRouting && forwardConfig.Direction != "socks5" {
+				return nil, fmt.Errorf("usernameRouting is only supported for socks5 in %s", section.Name())
+			}
+			if forwardConfig.CompressRelay && forwardConfig.Direction != "local" && forwardConfig.Direction != "reverse-socks5" {
+				return nil, fmt.Errorf("compressRelay is only supported for local and reverse-socks5 in %s", section.Name())
+			}
+			if value := section.Key("channelBufferSize").String(); value != "" {
+				size, err := parseByteSize(value)
+				if err != nil || size < minChannelBufferSize || size > maxChannelBufferSize {
+					return nil, fmt.Errorf("invalid channelBufferSize %q for %s (expected 4KB to 16MB)", value, section.Name())
+				}
+				forwardConfig.ChannelBufferSize = int(size)
+			}
+			if forwardConfig.OutboundIP != "" && net.ParseIP(forwardConfig.OutboundIP) == nil {
+				return nil, fmt.Errorf("invalid outboundIP %q for %s", forwardConfig.OutboundIP, section.Name())
+			}
+			for _, value := range splitList(section.Key("egressIPs").String()) {
+				ip := net.ParseIP(value)
+				if ip == nil {
+					return nil, fmt.Errorf("invalid egressIPs entry %q for %s", value, section.Name())
+				}
+				forwardConfig.EgressIPs = append(forwardConfig.EgressIPs, ip)
+			}
+			if len(forwardConfig.EgressIPs) > 0 {
+				if forwardConfig.Direction != "reverse-socks5" {
+					return nil, fmt.Errorf("egressIPs is only supported for reverse-socks5 in %s", section.Name())
+				}
+				if forwardConfig.OutboundIP != "" || forwardConfig.DialInterface != "" {
+					return nil, fmt.Errorf("egressIPs cannot be combined with outboundIP or dialInterface in %s", section.Name())
+				}
+			}
+			if forwardConfig.IPFamily, err = parseIPFamily(section.Key("ipFamily").String()); err != nil {
+				return nil, fmt.Errorf("%v for %s", err, section.Name())
+			}
+			if forwardConfig.IPFamily != "" {
+				if forwardConfig.Direction != "reverse-socks5" {
+					return nil, fmt.Errorf("ipFamily is only supported for reverse-socks5 in %s", section.Name())
+				}
+				if ip := net.ParseIP(forwardConfig.OutboundIP); ip != nil && (ip.To4() != nil) != (forwardConfig.IPFamily == familyIPv4) {
+					return nil, fmt.Errorf("outboundIP %s does not match ipFamily %s in %s", forwardConfig.OutboundIP, forwardConfig.IPFamily, section.Name())
+				}
+				for _, ip := range forwardConfig.EgressIPs {
+					if (ip.To4() != nil) != (forwardConfig.IPFamily == familyIPv4) {
+						return nil, fmt.Errorf("egressIPs entry %s does not match ipFamily %s in %s", ip, forwardConfig.IPFamily, section.Name())
+					}
+				}
+			}
+			forwardConfig.fingerprint = forwardFingerprint
+			config.Forwards = append(config.Forwards, forwardConfig)
+		}
+	}
+
+	// Link forward configs to their primary (first available) server config
+	for _, fc := range config.Forwards {
+		for _, serverName := range fc.ServerNames {
+			if sshConfig, ok := config.Servers[serverName]; ok {
+				if fc.SSHConfig == nil {
+					fc.SSHConfig = sshConfig
+				}
+			} else {
+				log.Printf("Warning: No server configuration found for %s in %s", serverName, fc.SectionName)
+			}
+		}
+		if fc.SSHConfig == nil {
+			log.Printf("Warning: No server configuration found for %s", fc.SectionName)
+		}
+		if fc.EgressServer != "" {
+			if fc.Direction != "reverse-socks5" {
+				return nil, fmt.Errorf("egressServer is only supported for reverse-socks5 in %s", fc.SectionName)
+			}
+			if _, ok := config.Servers[fc.EgressServer]; !ok {
+				return nil, fmt.Errorf("egressServer %s in %s is not a server section", fc.EgressServer, fc.SectionName)
+			}
+			if fc.ReverseEgress == reverseEgressLocal {
+				return nil, fmt.Errorf("egressServer cannot be combined with reverseEgress=local in %s", fc.SectionName)
+			}
+			if fc.OutboundIP != "" || len(fc.EgressIPs) > 0 || fc.DialInterface != "" || fc.IPFamily != "" {
+				return nil, fmt.Errorf("egressServer cannot be combined with outboundIP, egressIPs, dialInterface or ipFamily in %s", fc.SectionName)
+			}
+		}
+		switch fc.ReverseEgress {
+		case "", reverseEgressLocal:
+		case reverseEgressServer:
+			if fc.Direction != "reverse-socks5" {
+				return nil, fmt.Errorf("reverseEgress is only supported for reverse-socks5 in %s", fc.SectionName)
+			}
+			if fc.OutboundIP != "" || len(fc.EgressIPs) > 0 || fc.DialInterface != "" || fc.IPFamily != "" {
+				return nil, fmt.Errorf("reverseEgress=server cannot be combined with outboundIP, egressIPs, dialInterface or ipFamily in %s", fc.SectionName)
+			}
+		default:
+			return nil, fmt.Errorf("invalid reverseEgress %q in %s (expected local or server)", fc.ReverseEgress, fc.SectionName)
+		}
+	}
+
+	return config, nil
+}
+
+// parseSocks5Users reads the user:password list socks5Users and loads socks5UsersFile.
+func parseSocks5Users(fc *ForwardConfig, section *ini.Section) error {
+	value := section.Key("socks5Users").String()
+	if value == "" && fc.Socks5UsersFile == "" {
+		return nil
+	}
+	if fc.Direction != "socks5" && fc.Direction != "reverse-socks5" {
+		return fmt.Errorf("socks5Users and socks5UsersFile are only supported for socks5 and reverse-socks5 in %s", fc.SectionName)
+	}
+	if fc.UsernameRouting {
+		return fmt.Errorf("socks5Users and socks5UsersFile cannot be combined with usernameRouting in %s", fc.SectionName)
+	}
+	if value != "" {
+		users, err := parseCredentials(strings.Split(value, ","))
+		if err != nil {
+			return fmt.Errorf("invalid socks5Users for %s: %v", fc.SectionName, err)
+		}
+		fc.Socks5Users = users
+	}
+	if fc.Socks5UsersFile != "" {
+		credentials, err := loadCredentialFile(fc.Socks5UsersFile)
+		if err != nil {
+			return fmt.Errorf("invalid socks5UsersFile for %s: %v", fc.SectionName, err)
+		}
+		fc.credentials = credentials
+	}
+	return nil
+}
+
+// parseHealthCheck reads a forward's healthCheck options. healthCheckSend
+// may use Go escapes such as \r\n.
+func parseHealthCheck(fc *ForwardConfig, section *ini.Section) error {
+	value := section.Key("healthCheck").String()
+	if value == "" {
+		return nil
+	}
+	interval, err := time.ParseDuration(value)
+	if err != nil || interval <= 0 {
+		return fmt.Errorf("invalid healthCheck %q for %s (expected an interval like 30s)", value, fc.SectionName)
+	}
+	fc.HealthCheck = interval
+	fc.HealthCheckTarget = section.Key("healthCheckTarget").String()
+
+	if send := section.Key("healthCheckSend").String(); send != "" {
+		if fc.HealthCheckSend, err = strconv.Unquote(`"` + send + `"`); err != nil {
+			return fmt.Errorf("invalid healthCheckSend for %s: %v", fc.SectionName, err)
+		}
+	}
+
+	switch fc.Direction {
+	case "local":
+	case "socks5":
+		host, port, err := net.SplitHostPort(fc.HealthCheckTarget)
+		if err != nil {
+			return fmt.Errorf("socks5 healthCheck in %s needs healthCheckTarget as host:port", fc.SectionName)
+		}
+		if port, err = normalizePort(port); err != nil {
+			return fmt.Errorf("invalid healthCheckTarget port in %s: %v", fc.SectionName, err)
+		}
+		fc.HealthCheckTarget = net.JoinHostPort(host, port)
+		if fc.HealthCheckSend != "" {
+			return fmt.Errorf("healthCheckSend is only supported for local in %s", fc.SectionName)
+		}
+		if socks5AuthRequired(fc) && fc.Socks5User == "" {
+			return fmt.Errorf("socks5 healthCheck in %s needs socks5User and socks5Pass to log in", fc.SectionName)
+		}
+	default:
+		return fmt.Errorf("healthCheck is only supported for local and socks5 in %s", fc.SectionName)
+	}
+	if fc.TLSClientCA != "" {
+		return fmt.Errorf("healthCheck cannot be combined with tlsClientCA in %s", fc.SectionName)
+	}
+	return nil
+}
+
+// normalizeForwardPorts converts a forward's ports to numeric form, so that
+// typos are reported at load time and service names like "https" work.
+func normalizeForwardPorts(fc *ForwardConfig) error {
+	ports := []struct {
+		key   string
+		value *string
+	}{
+		{"localPort", &fc.LocalPort},
+		{"remotePort", &fc.RemotePort},
+	}
+	for _, port := range ports {
+		if *port.value == "" || (port.value == &fc.RemotePort && len(fc.RemotePorts) > 0) {
+			continue
+		}
+		normalized, err := normalizePort(*port.value)
+		if err != nil {
+			return fmt.Errorf("invalid %s for %s: %v", port.key, fc.SectionName, err)
+		}
+		*port.value = normalized
+	}
+	return nil
+}
+
+// parseDialOptions reads the SOCKS5 target dial timeout, retry count and
+// circuit breaker settings.
+func parseDialOptions(fc *ForwardConfig, section *ini.Section) error {
+	if value := section.Key("dialTimeout").String(); value != "" {
+		timeout, err := time.ParseDuration(value)
+		if err != nil || timeout <= 0 {
+			return fmt.Errorf("invalid dialTimeout %q for %s (expected a duration like 10s)", value, fc.SectionName)
+		}
+		fc.DialTimeout = timeout
+	}
+	if value := section.Key("dialRetries").String(); value != "" {
+		retries, err := strconv.Atoi(value)
+		if err != nil || retries < 0 {
+			return fmt.Errorf("invalid dialRetries %q for %s", value, fc.SectionName)
+		}
+		fc.DialRetries = retries
+	}
+
+	if value := section.Key("breakerFailures").String(); value != "" {
+		failures, err := strconv.Atoi(value)
+		if err != nil || failures < 0 {
+			return fmt.Errorf("invalid breakerFailures %q for %s", value, fc.SectionName)
+		}
+		fc.BreakerFailures = failures
+	}
+	fc.BreakerWindow = breakerDefaultWindow
+	fc.BreakerCooldown = breakerDefaultCooldown
+	durations := []struct {
+		key   string
+		value *time.Duration
+	}{
+		{"breakerWindow", &fc.BreakerWindow},
+		{"breakerCooldown", &fc.BreakerCooldown},
+	}
+	for _, d := range durations {
+		if value := section.Key(d.key).String(); value != "" {
+			duration, err := time.ParseDuration(value)
+			if err != nil || duration <= 0 {
+				return fmt.Errorf("invalid %s %q for %s (expected a duration like 30s)", d.key, value, fc.SectionName)
+			}
+			*d.value = duration
+		}
+	}
+	if fc.BreakerFailures > 0 {
+		fc.breaker = newTargetBreaker(fc.BreakerFailures, fc.BreakerWindow, fc.BreakerCooldown)
+	}
+	return nil
+}
+
+func validateResolve(fc *ForwardConfig) error {
+	switch fc.Resolve {
+	case "", resolveLocal:
+	case resolveRemote:
+		if fc.Direction == "reverse-socks5" && fc.DNSServer == "" {
+			return fmt.Errorf("resolve=remote on %s requires dnsServer", fc.SectionName)
+		}
+	default:
+		return fmt.Errorf("invalid resolve %q for %s (expected local or remote)", fc.Resolve, fc.SectionName)
+	}
+
+	if fc.DNSServer != "" {
+		if _, _, err := net.SplitHostPort(fc.DNSServer); err != nil {
+			fc.DNSServer = net.JoinHostPort(fc.DNSServer, "53")
+		}
+	}
+	return nil
+}
+
+// mergeIncludes appends the files listed by [common] include to cfg. Patterns
+// are comma-separated globs relative to the main config file's directory, and
+// later files override keys of sections they share with earlier ones.
+func mergeIncludes(cfg *ini.File, path string) error {
+	if !cfg.HasSection("common") {
+		return nil
+	}
+	patterns := splitList(cfg.Section("common").Key("include").String())
+
+	for _, pattern := range patterns {
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(filepath.Dir(path), pattern)
+		}
+		files, err := filepath.Glob(pattern)
+		if err != nil {
+			return fmt.Errorf("invalid include pattern %q: %v", pattern, err)
+		}
+		if len(files) == 0 && !strings.ContainsAny(pattern, "*?[") {
+			return fmt.Errorf("included file %s not found", pattern)
+		}
+		for _, file := range files {
+			if err := cfg.Append(file); err != nil {
+				return fmt.Errorf("failed to merge %s: %v", file, err)
+			}
+			log.Printf("Merged configuration from %s", file)
+		}
+	}
+	return nil
+}
+
+// applyTransport sets up the DialFunc for a server's transport option.
+func applyTransport(serverConfig *ServerConfig, transport, wsURL string) error {
+	switch transport {
+	case "", transportTCP:
+		return nil
+	case transportWebSocket:
+		host, err := validateWebSocketURL(wsURL)
+		if err != nil {
+			return fmt.Errorf("invalid wsURL %q: %v", wsURL, err)
+		}
+		// The SSH address is only informational over a WebSocket
+		if serverConfig.Server == "" {
+			serverConfig.Server = host
+		}
+		serverConfig.DialFunc = websocketDialFunc(wsURL)
+		serverConfig.wsURL = wsURL
+		return nil
+	default:
+		return fmt.Errorf("invalid transport %q (expected tcp or websocket)", transport)
+	}
+}
+
+// sectionFingerprint hashes the keys and values of a section, in sorted
+// order, together with extra values its settings depend on.
+func sectionFingerprint(section *ini.Section, extra ...string) string {
+	keys := section.KeyStrings()
+	sort.Strings(keys)
+	hash := sha256.New()
+	for _, key := range keys {
+		fmt.Fprintf(hash, "%s=%s\n", key, section.Key(key).Value())
+	}
+	for _, value := range extra {
+		fmt.Fprintf(hash, "\x00%s\n", value)
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// splitList splits a comma-separated option into its trimmed, non-empty items.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// parseByteSize parses a size such as 65536, 64KB, 1MB or 4GB (powers of
+// 1024).
+func parseByteSize(value string) (int64, error) {
+	number := strings.ToUpper(strings.TrimSpace(value))
+	multiplier := int64(1)
+	for _, unit := range []struct {
+		suffix string
+		factor int64
+	}{{"KB", 1 << 10}, {"K", 1 << 10}, {"MB", 1 << 20}, {"M", 1 << 20}, {"GB", 1 << 30}, {"G", 1 << 30}, {"B", 1}} {
+		if strings.HasSuffix(number, unit.suffix) {
+			number = strings.TrimSpace(strings.TrimSuffix(number, unit.suffix))
+			multiplier = unit.factor
+			break
+		}
+	}
+	size, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || size < 0 || size > math.MaxInt64/multiplier {
+		return 0, fmt.Errorf("invalid size %q", value)
+	}
+	return size * multiplier, nil
+}
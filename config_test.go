@@ -0,0 +1,90 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// loadTestConfig writes content to a config file in a temporary directory and
+// loads it.
+func loadTestConfig(t *testing.T, content string) *Config {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.ini")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	config, err := loadConfig(path)
+	if err != nil {
+		t.Fatalf("loadConfig: %v", err)
+	}
+	return config
+}
+
+func TestCommonSocks5Credentials(t *testing.T) {
+	config := loadTestConfig(t, `
+[common]
+socks5User=shared
+socks5Pass=sharedpass
+
+[serverA]
+server=127.0.0.1
+user=tester
+password=secret
+
+[inherit]
+server=serverA
+direction=socks5
+localPort=1080
+
+[own]
+server=serverA
+direction=socks5
+localPort=1081
+socks5User=alice
+socks5Pass=alicepass
+
+[optout]
+server=serverA
+direction=socks5
+localPort=1082
+socks5User=
+
+[reverse]
+server=serverA
+direction=reverse-socks5
+remotePort=1083
+
+[web]
+server=serverA
+direction=local
+localPort=8080
+remoteIP=127.0.0.1
+remotePort=80
+`)
+
+	tests := []struct {
+		section  string
+		user     string
+		password string
+	}{
+		{"inherit", "shared", "sharedpass"},
+		{"own", "alice", "alicepass"},
+		{"optout", "", ""},
+		{"reverse", "shared", "sharedpass"},
+		{"web", "", ""},
+	}
+	forwards := make(map[string]*ForwardConfig)
+	for _, fc := range config.Forwards {
+		forwards[fc.SectionName] = fc
+	}
+	for _, tt := range tests {
+		fc := forwards[tt.section]
+		if fc == nil {
+			t.Fatalf("forward %s not loaded", tt.section)
+		}
+		if fc.Socks5User != tt.user || fc.Socks5Pass != tt.password {
+			t.Errorf("%s: got %q/%q, want %q/%q", tt.section, fc.Socks5User, fc.Socks5Pass, tt.user, tt.password)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+)
+
+// IDs of the accepted connections being handled, by connection, while
+// connectionIDs is set
+var connectionIDs sync.Map
+
+// newConnectionID returns a random (version 4) UUID.
+func newConnectionID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// traceConnection returns the handler of conn, just accepted by the forward.
+// With connectionIDs set, conn gets a UUID, logged along with the client
+// address, which then prefixes the log lines about conn until handle returns.
+func traceConnection(commonConfig *CommonConfig, config *ForwardConfig, conn net.Conn, handle func()) func() {
+	if !commonConfig.ConnectionIDs {
+		return handle
+	}
+	id := newConnectionID()
+	log.Printf("[%s] Accepted connection from %s on %s", id, conn.RemoteAddr(), config.SectionName)
+	return func() {
+		connectionIDs.Store(conn, id)
+		defer connectionIDs.Delete(conn)
+		handle()
+	}
+}
+
+// connPrefix returns the log prefix of conn: its ID in brackets, or nothing
+// when it has none.
+func connPrefix(conn net.Conn) string {
+	if buffered, ok := conn.(*bufferedConn); ok {
+		conn = buffered.Conn
+	}
+	if id, ok := connectionIDs.Load(conn); ok {
+		return "[" + id.(string) + "] "
+	}
+	return ""
+}
+
+// connLogf logs a line about conn, prefixed with its ID if it has one.
+func connLogf(conn net.Conn, format string, args ...interface{}) {
+	log.Printf(connPrefix(conn)+format, args...)
+}
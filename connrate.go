@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"sync"
+	"time"
+
+	"gopkg.in/ini.v1"
+)
+
+// connRateExcess values: close connections over maxConnRate, or hold them
+// until the rate allows them
+const (
+	connRateClose = "close"
+	connRateDelay = "delay"
+)
+
+// How often closed connections over maxConnRate are summarized in the log
+const connRateLogInterval = 10 * time.Second
+
+// parseConnRate reads maxConnRate, connRateBurst and connRateExcess.
+func parseConnRate(fc *ForwardConfig, section *ini.Section) error {
+	fc.MaxConnRate = section.Key("maxConnRate").MustFloat64(0)
+	fc.ConnRateExcess = section.Key("connRateExcess").MustString(connRateClose)
+
+	if fc.MaxConnRate < 0 || math.IsInf(fc.MaxConnRate, 0) || math.IsNaN(fc.MaxConnRate) {
+		return fmt.Errorf("invalid maxConnRate for %s (expected connections per second, e.g. 5 or 0.5)", fc.SectionName)
+	}
+	if fc.ConnRateExcess != connRateClose && fc.ConnRateExcess != connRateDelay {
+		return fmt.Errorf("invalid connRateExcess %q for %s (expected close or delay)", fc.ConnRateExcess, fc.SectionName)
+	}
+	if fc.MaxConnRate == 0 {
+		if section.HasKey("connRateBurst") {
+			return fmt.Errorf("connRateBurst for %s needs maxConnRate", fc.SectionName)
+		}
+		return nil
+	}
+	switch fc.Direction {
+	case "local", "socks5", "sni-route":
+	default:
+		return fmt.Errorf("maxConnRate on %s is only supported for directions with a local listener", fc.SectionName)
+	}
+	fc.ConnRateBurst = section.Key("connRateBurst").MustInt(int(math.Max(1, math.Ceil(fc.MaxConnRate))))
+	if fc.ConnRateBurst < 1 {
+		return fmt.Errorf("invalid connRateBurst %d for %s", fc.ConnRateBurst, fc.SectionName)
+	}
+	return nil
+}
+
+// wrapListenerRate returns listener limited to the forward's maxConnRate, or
+// listener itself when it is not set.
+func wrapListenerRate(listener net.Listener, fc *ForwardConfig) net.Listener {
+	if fc.MaxConnRate == 0 {
+		return listener
+	}
+	return &rateListener{
+		Listener: listener,
+		config:   fc,
+		tokens:   float64(fc.ConnRateBurst),
+		last:     time.Now(),
+		closed:   make(chan struct{}),
+	}
+}
+
+// rateListener admits accepted connections through a token bucket refilled
+// at maxConnRate tokens per second and holding up to connRateBurst. A
+// connection finding the bucket empty is closed, or held until a token is
+// available with connRateExcess=delay, which leaves later clients waiting in
+// the listen backlog meanwhile.
+type rateListener struct {
+	net.Listener
+	config *ForwardConfig
+	tokens float64
+	last   time.Time
+
+	// Connections closed since the last log line
+	dropped   int
+	loggedAt  time.Time
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func (l *rateListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		wait := l.take()
+		if wait == 0 {
+			return conn, nil
+		}
+		if l.config.ConnRateExcess == connRateDelay {
+			select {
+			case <-time.After(wait):
+				return conn, nil
+			case <-l.closed:
+				conn.Close()
+				return nil, net.ErrClosed
+			}
+		}
+		l.logDropped(conn.RemoteAddr())
+		conn.Close()
+	}
+}
+
+// take uses a token, returning zero when one was available. Otherwise it
+// returns how long until one is; with connRateExcess=delay that token is
+// taken in advance.
+func (l *rateListener) take() time.Duration {
+	now := time.Now()
+	l.tokens = math.Min(float64(l.config.ConnRateBurst), l.tokens+now.Sub(l.last).Seconds()*l.config.MaxConnRate)
+	l.last = now
+	if l.tokens >= 1 {
+		l.tokens--
+		return 0
+	}
+	wait := time.Duration((1 - l.tokens) / l.config.MaxConnRate * float64(time.Second))
+	if l.config.ConnRateExcess == connRateDelay {
+		l.tokens--
+	}
+	return wait
+}
+
+// logDropped logs the first closed connection, then at most one summary per
+// connRateLogInterval, so a scan does not flood the log.
+func (l *rateListener) logDropped(addr net.Addr) {
+	l.dropped++
+	if time.Since(l.loggedAt) < connRateLogInterval {
+		return
+	}
+	if l.dropped == 1 {
+		log.Printf("Rejected connection from %s to %s: over maxConnRate", addr, l.config.SectionName)
+	} else {
+		log.Printf("Rejected %d connections to %s over maxConnRate, latest from %s", l.dropped, l.config.SectionName, addr)
+	}
+	l.dropped = 0
+	l.loggedAt = time.Now()
+}
+
+func (l *rateListener) Close() error {
+	l.closeOnce.Do(func() { close(l.closed) })
+	return l.Listener.Close()
+}
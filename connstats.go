@@ -0,0 +1,75 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Connection history of one server, kept across reconnects
+type connectionStats struct {
+	connects    int       // connections established so far
+	connectedAt time.Time // when the current connection was established
+	lastSeen    time.Time // last successful keepalive or connect
+	version     string    // identification string of the latest connection
+	state       string    // one of the state* constants
+}
+
+// ServerStats is a snapshot of a server's connection history
+type ServerStats struct {
+	ConnectedAt time.Time
+	LastSeen    time.Time
+	Reconnects  int
+	// Server software as reported by the latest connection, e.g.
+	// SSH-2.0-OpenSSH_9.6p1
+	Version string
+	// Connection state, one of connecting, connected, degraded,
+	// reconnecting or failed
+	State string
+}
+
+// recordConnect counts a newly established connection and notes the server
+// version it reported. Callers hold cm.mutex.
+func (cm *ConnectionManager) recordConnect(serverName string, conn *ssh.Client) {
+	stats := cm.statsFor(serverName)
+	now := time.Now()
+	stats.connects++
+	stats.connectedAt = now
+	stats.lastSeen = now
+	stats.version = string(conn.ServerVersion())
+	cm.setState(serverName, stateConnected)
+}
+
+// recordKeepalive notes a successful keepalive on the server's connection,
+// which also ends a degraded state.
+func (cm *ConnectionManager) recordKeepalive(serverName string) {
+	cm.mutex.Lock()
+	defer cm.mutex.Unlock()
+	if stats, ok := cm.stats[serverName]; ok {
+		stats.lastSeen = time.Now()
+		if stats.state == stateDegraded {
+			log.Printf("SSH keepalive for server %s answered again", serverName)
+		}
+		cm.setState(serverName, stateConnected)
+	}
+}
+
+// Stats returns the connection history of a server. Reconnects counts every
+// connection after the first, and is 0 until one succeeds. A server not
+// dialed yet is connecting.
+func (cm *ConnectionManager) Stats(serverName string) ServerStats {
+	cm.mutex.RLock()
+	defer cm.mutex.RUnlock()
+	stats, ok := cm.stats[serverName]
+	if !ok {
+		return ServerStats{State: stateConnecting}
+	}
+	return ServerStats{
+		ConnectedAt: stats.connectedAt,
+		LastSeen:    stats.lastSeen,
+		Reconnects:  max(stats.connects-1, 0),
+		Version:     stats.version,
+		State:       stats.state,
+	}
+}
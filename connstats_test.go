@@ -0,0 +1,25 @@
+package main
+
+import "testing"
+
+func TestStatsDialedNeverConnected(t *testing.T) {
+	cm := &ConnectionManager{stats: make(map[string]*connectionStats)}
+
+	if stats := cm.Stats("serverA"); stats.Reconnects != 0 || stats.State != stateConnecting {
+		t.Errorf("before dialing: got %d reconnects, state %s", stats.Reconnects, stats.State)
+	}
+
+	cm.mutex.Lock()
+	cm.beginDial("serverA")
+	cm.mutex.Unlock()
+	if stats := cm.Stats("serverA"); stats.Reconnects != 0 || stats.State != stateConnecting {
+		t.Errorf("while dialing: got %d reconnects, state %s", stats.Reconnects, stats.State)
+	}
+
+	cm.mutex.Lock()
+	cm.setState("serverA", stateFailed)
+	cm.mutex.Unlock()
+	if stats := cm.Stats("serverA"); stats.Reconnects != 0 || stats.State != stateFailed {
+		t.Errorf("after failing: got %d reconnects, state %s", stats.Reconnects, stats.State)
+	}
+}
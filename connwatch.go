@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// trackConnection registers a new connection so forwards using it can be told
+// when it is lost. Callers hold cm.mutex.
+func (cm *ConnectionManager) trackConnection(conn *ssh.Client) {
+	cm.lost[conn] = make(chan struct{})
+	cm.channels[conn] = new(int64)
+}
+
+// markLost closes conn and wakes every forward watching it. Callers hold
+// cm.mutex.
+func (cm *ConnectionManager) markLost(conn *ssh.Client) {
+	conn.Close()
+	if lost, ok := cm.lost[conn]; ok {
+		close(lost)
+		delete(cm.lost, conn)
+	}
+	delete(cm.channels, conn)
+}
+
+// connectionContext returns a context that is cancelled with parent or as
+// soon as conn is lost. A forward running under it closes its listener when
+// the monitor finds the connection dead, so the reconnect loop can restart it
+// right away instead of waiting for an Accept or Dial error.
+func (cm *ConnectionManager) connectionContext(parent context.Context, conn *ssh.Client) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(parent)
+
+	cm.mutex.RLock()
+	lost, ok := cm.lost[conn]
+	cm.mutex.RUnlock()
+	if !ok {
+		// Already lost or closed
+		cancel()
+		return ctx, cancel
+	}
+
+	go func() {
+		select {
+		case <-lost:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
@@ -0,0 +1,233 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Status snapshot returned by the control API
+type StatusSnapshot struct {
+	Servers  []ServerStatus  `json:"servers"`
+	Forwards []ForwardStatus `json:"forwards"`
+}
+
+type ServerStatus struct {
+	Name          string `json:"name"`
+	Address       string `json:"address"`
+	User          string `json:"user"`
+	Connected     bool   `json:"connected"`
+	State         string `json:"state"` // connecting, connected, degraded, reconnecting or failed
+	ConnectedAt   string `json:"connectedAt,omitempty"`
+	UptimeSeconds int64  `json:"uptimeSeconds,omitempty"`
+	LastSeen      string `json:"lastSeen,omitempty"`
+	Reconnects    int    `json:"reconnects"`
+	Version       string `json:"version,omitempty"` // as reported by the latest connection
+	PoolSize      int    `json:"poolSize,omitempty"`
+	PoolConnected int    `json:"poolConnected,omitempty"`
+}
+
+type ForwardStatus struct {
+	Name         string            `json:"name"`
+	Server       string            `json:"server"`
+	ActiveServer string            `json:"activeServer,omitempty"`
+	Direction    string            `json:"direction"`
+	Local        string            `json:"local,omitempty"`
+	Remote       string            `json:"remote,omitempty"`
+	Listen       string            `json:"listen,omitempty"` // bound address, with the chosen port for port 0
+	Running      bool              `json:"running"`
+	Health       *HealthStatus     `json:"health,omitempty"`
+	Labels       map[string]string `json:"labels,omitempty"`
+}
+
+// HealthStatus is the latest health check of a forward with healthCheck
+type HealthStatus struct {
+	CheckedAt string `json:"checkedAt"`
+	OK        bool   `json:"ok"`
+	RTTMillis int64  `json:"rttMs"`
+	Error     string `json:"error,omitempty"`
+}
+
+func getStatusSnapshot() *StatusSnapshot {
+	snapshot := &StatusSnapshot{
+		Servers:  []ServerStatus{},
+		Forwards: []ForwardStatus{},
+	}
+
+	forwardsMutex.Lock()
+	defer forwardsMutex.Unlock()
+
+	loaded := loadedServers()
+	var serverNames []string
+	for name := range loaded {
+		serverNames = append(serverNames, name)
+	}
+	sort.Strings(serverNames)
+
+	for _, name := range serverNames {
+		serverConfig := loaded[name]
+		connManager.mutex.RLock()
+		conn, exists := connManager.connections[name]
+		connManager.mutex.RUnlock()
+		stats := connManager.Stats(name)
+
+		status := ServerStatus{
+			Name:       name,
+			Address:    net.JoinHostPort(serverConfig.Server, serverConfig.Port),
+			User:       serverConfig.User,
+			Connected:  exists && conn != nil,
+			State:      stats.State,
+			Reconnects: stats.Reconnects,
+			Version:    stats.Version,
+		}
+		if serverConfig.PoolSize > 1 {
+			status.PoolSize = serverConfig.PoolSize
+			status.PoolConnected = connManager.poolConnected(name, serverConfig.PoolSize)
+		}
+		if !stats.LastSeen.IsZero() {
+			status.LastSeen = stats.LastSeen.Format(time.RFC3339)
+		}
+		if status.Connected {
+			status.ConnectedAt = stats.ConnectedAt.Format(time.RFC3339)
+			status.UptimeSeconds = int64(time.Since(stats.ConnectedAt).Seconds())
+		}
+		snapshot.Servers = append(snapshot.Servers, status)
+	}
+
+	for _, name := range forwardOrder {
+		state := forwards[name]
+		fc := state.config
+		status := ForwardStatus{
+			Name:         name,
+			Server:       fc.ServerName,
+			ActiveServer: fc.ActiveServer(),
+			Direction:    fc.Direction,
+			Listen:       fc.ListenAddr(),
+			Running:      state.cancel != nil,
+			Labels:       fc.Labels,
+		}
+		if fc.LocalPort != "" {
+			status.Local = net.JoinHostPort(fc.LocalIP, fc.LocalPort)
+		}
+		if fc.RemotePort != "" || fc.RemoteSocket != "" {
+			status.Remote = fc.remoteTarget()
+		}
+		if health := fc.Health(); !health.CheckedAt.IsZero() {
+			status.Health = &HealthStatus{
+				CheckedAt: health.CheckedAt.Format(time.RFC3339),
+				OK:        health.OK,
+				RTTMillis: health.RTT.Milliseconds(),
+				Error:     health.Error,
+			}
+		}
+		snapshot.Forwards = append(snapshot.Forwards, status)
+	}
+
+	return snapshot
+}
+
+// startControlServer serves the HTTP control API on the given address:
+//
+//	GET  /status                 JSON status snapshot
+//	GET  /metrics                status in the Prometheus text format
+//	POST /reload                 reload config.ini
+//	POST /forwards/{name}/stop   stop a forward
+//	POST /forwards/{name}/start  start a stopped forward
+func startControlServer(address string) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %v", address, err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", handleControlStatus)
+	mux.HandleFunc("/metrics", handleControlMetrics)
+	mux.HandleFunc("/reload", handleControlReload)
+	mux.HandleFunc("/forwards/", handleControlForward)
+
+	server := &http.Server{Handler: mux}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.Printf("Control API stopped: %v", err)
+		}
+	}()
+
+	log.Printf("Control API listening on %s", listener.Addr())
+	return nil
+}
+
+func handleControlStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeControlJSON(w, http.StatusOK, getStatusSnapshot())
+}
+
+func handleControlReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := reloadConfig(); err != nil {
+		writeControlError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeControlJSON(w, http.StatusOK, getStatusSnapshot())
+}
+
+func handleControlForward(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Path is /forwards/{name}/{action}
+	path := strings.TrimPrefix(r.URL.Path, "/forwards/")
+	slash := strings.LastIndex(path, "/")
+	if slash <= 0 {
+		http.NotFound(w, r)
+		return
+	}
+	name, action := path[:slash], path[slash+1:]
+
+	var err error
+	switch action {
+	case "start":
+		err = startForward(name)
+	case "stop":
+		err = stopForward(name)
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		writeControlError(w, http.StatusNotFound, err)
+		return
+	}
+	writeControlJSON(w, http.StatusOK, getStatusSnapshot())
+}
+
+func writeControlJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(v)
+}
+
+func writeControlError(w http.ResponseWriter, status int, err error) {
+	writeControlJSON(w, status, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// credentialFile is an htpasswd-style file of SOCKS5 logins. It is read again
+// when it changes, so editing it adds or revokes clients without restarting
+// the forward.
+type credentialFile struct {
+	path    string
+	mutex   sync.Mutex
+	modTime time.Time
+	size    int64
+	users   map[string]string
+}
+
+// loadCredentialFile reads path, which must exist and parse.
+func loadCredentialFile(path string) (*credentialFile, error) {
+	f := &credentialFile{path: path}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.load(info); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *credentialFile) load(info os.FileInfo) error {
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		return err
+	}
+	users, err := parseCredentials(strings.Split(string(data), "\n"))
+	if err != nil {
+		return err
+	}
+	f.users, f.modTime, f.size = users, info.ModTime(), info.Size()
+	return nil
+}
+
+// lookup returns the stored password of username, reading the file first if
+// it changed. A file that became unreadable or invalid keeps the logins it
+// had before.
+func (f *credentialFile) lookup(username string) (string, bool) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if info, err := os.Stat(f.path); err != nil {
+		log.Printf("Warning: cannot check SOCKS5 users file %s: %v", f.path, err)
+	} else if !info.ModTime().Equal(f.modTime) || info.Size() != f.size {
+		if err := f.load(info); err != nil {
+			log.Printf("Warning: keeping previous SOCKS5 users, failed to reload %s: %v", f.path, err)
+		} else {
+			log.Printf("Reloaded SOCKS5 users from %s (%d users)", f.path, len(f.users))
+		}
+	}
+	stored, ok := f.users[username]
+	return stored, ok
+}
+
+// parseCredentials parses user:password entries. Passwords may be stored in
+// plain text, as bcrypt hashes ($2y$...) or as {SHA} hashes, the formats
+// htpasswd writes with -p, -B and -s; blank lines and # comments are skipped.
+func parseCredentials(entries []string) (map[string]string, error) {
+	users := make(map[string]string)
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+		username, password, ok := strings.Cut(entry, ":")
+		if !ok || username == "" {
+			return nil, fmt.Errorf("invalid entry %q (expected user:password)", entry)
+		}
+		if strings.HasPrefix(password, "$apr1$") || strings.HasPrefix(password, "$1$") || strings.HasPrefix(password, "$5$") || strings.HasPrefix(password, "$6$") {
+			return nil, fmt.Errorf("unsupported password hash for %s (use bcrypt, e.g. htpasswd -B)", username)
+		}
+		users[username] = password
+	}
+	return users, nil
+}
+
+// checkPassword compares a password presented by a client with a stored one
+// in any format parseCredentials accepts.
+func checkPassword(stored, password string) bool {
+	switch {
+	case strings.HasPrefix(stored, "$2"):
+		return bcrypt.CompareHashAndPassword([]byte(stored), []byte(password)) == nil
+	case strings.HasPrefix(stored, "{SHA}"):
+		sum := sha1.Sum([]byte(password))
+		return stored[len("{SHA}"):] == base64.StdEncoding.EncodeToString(sum[:])
+	default:
+		return stored == password
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"time"
+)
+
+// Values of preferAddressFamily and ipFamily
+const (
+	familyAuto = "auto"
+	familyIPv4 = "ipv4"
+	familyIPv6 = "ipv6"
+)
+
+// parseIPFamily validates an ipFamily value; auto is returned as "".
+func parseIPFamily(value string) (string, error) {
+	switch value {
+	case "", familyAuto:
+		return "", nil
+	case familyIPv4, familyIPv6:
+		return value, nil
+	}
+	return "", fmt.Errorf("invalid ipFamily %q (expected auto, ipv4 or ipv6)", value)
+}
+
+// familyNetwork narrows a network such as "tcp" or "ip" to family, so both
+// dialing and name resolution stay within it.
+func familyNetwork(network, family string) string {
+	switch family {
+	case familyIPv4:
+		return network + "4"
+	case familyIPv6:
+		return network + "6"
+	}
+	return network
+}
+
+// dialAddresses opens a TCP connection to address, trying each IP its host
+// resolves to in turn until one connects. Addresses alternate between the
+// families, starting with the server's preferAddressFamily, so a dead
+// address or a broken family only costs one timeout each. With ipFamily only
+// addresses of that family are looked up and dialed.
+func dialAddresses(serverConfig *ServerConfig, address string, timeout time.Duration) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	network := familyNetwork("tcp", serverConfig.IPFamily)
+	if net.ParseIP(host) != nil {
+		return net.DialTimeout(network, address, timeout)
+	}
+
+	lookupCtx, cancel := context.WithCancel(context.Background())
+	if timeout > 0 {
+		lookupCtx, cancel = context.WithTimeout(context.Background(), timeout)
+	}
+	ips, err := net.DefaultResolver.LookupIP(lookupCtx, familyNetwork("ip", serverConfig.IPFamily), host)
+	cancel()
+	if err != nil {
+		return nil, err
+	}
+
+	var errs []error
+	for _, ip := range orderAddresses(ips, serverConfig.PreferAddressFamily) {
+		target := net.JoinHostPort(ip.String(), port)
+		conn, err := net.DialTimeout(network, target, timeout)
+		if err == nil {
+			if len(ips) > 1 {
+				log.Printf("Connected to %s at %s", host, ip)
+			}
+			return conn, nil
+		}
+		errs = append(errs, err)
+	}
+	return nil, fmt.Errorf("all %d addresses of %s failed: %v", len(ips), host, errors.Join(errs...))
+}
+
+// orderAddresses interleaves IPv4 and IPv6 addresses, keeping the resolver's
+// order within each family. The preferred family goes first, or the family
+// of the first address when there is no preference.
+func orderAddresses(ips []net.IP, prefer string) []net.IP {
+	var v4, v6 []net.IP
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			v4 = append(v4, ip)
+		} else {
+			v6 = append(v6, ip)
+		}
+	}
+
+	first, second := v4, v6
+	if prefer == familyIPv6 || (prefer == "" && len(ips) > 0 && ips[0].To4() == nil) {
+		first, second = v6, v4
+	}
+
+	ordered := make([]net.IP, 0, len(ips))
+	for i := 0; i < len(first) || i < len(second); i++ {
+		if i < len(first) {
+			ordered = append(ordered, first[i])
+		}
+		if i < len(second) {
+			ordered = append(ordered, second[i])
+		}
+	}
+	return ordered
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Named DSCP classes accepted by the dscp option besides numbers
+var dscpNames = map[string]int{
+	"ef": 46, "va": 44,
+	"cs0": 0, "cs1": 8, "cs2": 16, "cs3": 24, "cs4": 32, "cs5": 40, "cs6": 48, "cs7": 56,
+	"af11": 10, "af12": 12, "af13": 14, "af21": 18, "af22": 20, "af23": 22,
+	"af31": 26, "af32": 28, "af33": 30, "af41": 34, "af42": 36, "af43": 38,
+}
+
+// parseDSCP parses a DSCP value from 0 to 63 or a class name like EF or AF41.
+func parseDSCP(value string) (int, error) {
+	if dscp, ok := dscpNames[strings.ToLower(value)]; ok {
+		return dscp, nil
+	}
+	dscp, err := strconv.Atoi(value)
+	if err != nil || dscp < 0 || dscp > 63 {
+		return 0, fmt.Errorf("invalid dscp %q (expected 0-63 or a class like EF or AF41)", value)
+	}
+	return dscp, nil
+}
+
+// setConnDSCP marks the packets of a TCP connection with dscp.
+func setConnDSCP(conn net.Conn, dscp int) error {
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return fmt.Errorf("not a socket")
+	}
+	rawConn, err := sc.SyscallConn()
+	if err != nil {
+		return err
+	}
+	return dscpControl(dscp)("", "", rawConn)
+}
+
+// wrapListenerDSCP returns listener marking every accepted connection with
+// the forward's dscp, or listener itself when dscp is not set.
+func wrapListenerDSCP(listener net.Listener, fc *ForwardConfig) net.Listener {
+	if fc.DSCP == 0 || !dscpSupported {
+		return listener
+	}
+	return &dscpListener{Listener: listener, config: fc}
+}
+
+// dscpListener sets the DSCP of accepted connections
+type dscpListener struct {
+	net.Listener
+	config *ForwardConfig
+}
+
+func (l *dscpListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err == nil {
+		if err := setConnDSCP(conn, l.config.DSCP); err != nil {
+			log.Printf("Failed to set dscp on connection to %s: %v", l.config.SectionName, err)
+		}
+	}
+	return conn, err
+}
+
+// dscpDialer returns a dialer whose connections carry the forward's dscp.
+func dscpDialer(fc *ForwardConfig) *net.Dialer {
+	dialer := &net.Dialer{}
+	if fc.DSCP != 0 && dscpSupported {
+		dialer.Control = dscpControl(fc.DSCP)
+	}
+	return dialer
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"syscall"
+)
+
+// Whether the dscp option has an effect on this platform
+const dscpSupported = true
+
+// dscpControl returns a socket control function setting the traffic class
+// of IPv4 and IPv6 sockets to dscp. Only the option matching the socket's
+// family can succeed, so it fails only when both do.
+func dscpControl(dscp int) func(network, address string, c syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		var sockErr error
+		err := c.Control(func(fd uintptr) {
+			tos := dscp << 2
+			errV4 := syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_TOS, tos)
+			errV6 := syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_TCLASS, tos)
+			if errV4 != nil && errV6 != nil {
+				sockErr = errV4
+			}
+		})
+		if err != nil {
+			return err
+		}
+		return sockErr
+	}
+}
@@ -0,0 +1,18 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"syscall"
+)
+
+// Whether the dscp option has an effect on this platform
+const dscpSupported = false
+
+// dscpControl leaves sockets unchanged; dscp is only supported on Linux.
+func dscpControl(dscp int) func(network, address string, c syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		return nil
+	}
+}
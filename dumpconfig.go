@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+)
+
+// Config fields holding secrets, replaced in the dump
+var redactedFields = map[string]bool{
+	"Password":    true,
+	"Socks5Pass":  true,
+	"Socks5Users": true,
+}
+
+// writeConfigJSON writes the loaded configuration as JSON, after includes,
+// remote config, ssh_config lookups and defaults have been applied. Secrets
+// are redacted and unset values left out. The configs are dumped as struct
+// values, whose fields are listed rather than their masked String.
+func writeConfigJSON(w io.Writer, config *Config) error {
+	serverDump := make(map[string]any, len(config.Servers))
+	for name, serverConfig := range config.Servers {
+		serverDump[name] = dumpValue(reflect.ValueOf(serverConfig).Elem())
+	}
+	forwards := make([]any, 0, len(config.Forwards))
+	for _, fc := range config.Forwards {
+		forwards = append(forwards, dumpValue(reflect.ValueOf(fc).Elem()))
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.SetEscapeHTML(false)
+	return encoder.Encode(map[string]any{
+		"common":   dumpValue(reflect.ValueOf(config.Common).Elem()),
+		"servers":  serverDump,
+		"forwards": forwards,
+	})
+}
+
+// dumpValue converts a config value to plain JSON-encodable data: exported
+// struct fields by name, values with a String method (durations, addresses)
+// as text. Functions, channels and the server a forward links to are
+// skipped, and zero values come back as nil.
+func dumpValue(v reflect.Value) any {
+	if !v.IsValid() || v.IsZero() {
+		return nil
+	}
+	if stringer, ok := v.Interface().(fmt.Stringer); ok {
+		return stringer.String()
+	}
+
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		return dumpValue(v.Elem())
+	case reflect.Struct:
+		fields := make(map[string]any)
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			if !field.IsExported() || field.Type == reflect.TypeOf((*ServerConfig)(nil)) {
+				continue
+			}
+			value := dumpValue(v.Field(i))
+			if value == nil {
+				continue
+			}
+			if redactedFields[field.Name] {
+				value = redactedValue
+			}
+			fields[field.Name] = value
+		}
+		if len(fields) == 0 {
+			return nil
+		}
+		return fields
+	case reflect.Slice, reflect.Array:
+		items := make([]any, 0, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			items = append(items, dumpValue(v.Index(i)))
+		}
+		return items
+	case reflect.Map:
+		entries := make(map[string]any, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			entries[fmt.Sprint(iter.Key().Interface())] = dumpValue(iter.Value())
+		}
+		return entries
+	case reflect.Func, reflect.Chan:
+		return nil
+	}
+	return v.Interface()
+}
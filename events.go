@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// Events buffered per subscriber; a subscriber that falls further behind
+// misses events instead of stalling the forwards
+const eventBuffer = 256
+
+// Event is one line of the event stream
+type Event struct {
+	Time     time.Time `json:"time"`
+	Type     string    `json:"type"` // connect, disconnect, state, bytes or error
+	Server   string    `json:"server,omitempty"`
+	Forward  string    `json:"forward,omitempty"`
+	Client   string    `json:"client,omitempty"`
+	Target   string    `json:"target,omitempty"`
+	Sent     int64     `json:"sent,omitempty"`
+	Received int64     `json:"received,omitempty"`
+	Duration float64   `json:"durationSeconds,omitempty"`
+	Error    string    `json:"error,omitempty"`
+	State    string    `json:"state,omitempty"` // new server state, for state events
+	// Labels of the forward
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// eventHub fans events out to the clients of the event socket
+type eventHub struct {
+	mutex   sync.Mutex
+	clients map[chan []byte]struct{}
+}
+
+// Set when eventSocket is configured; nil disables events
+var events *eventHub
+
+// startEventSocket listens on the Unix socket at path and streams
+// newline-delimited JSON events to every client that connects.
+func startEventSocket(path string) error {
+	// Remove a socket left behind by a previous run
+	if info, err := os.Stat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %v", path, err)
+	}
+
+	events = &eventHub{clients: make(map[chan []byte]struct{})}
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go events.serve(conn)
+		}
+	}()
+
+	log.Printf("Event socket listening on %s", path)
+	return nil
+}
+
+// serve writes events to conn until it fails or closes.
+func (h *eventHub) serve(conn net.Conn) {
+	queue := make(chan []byte, eventBuffer)
+	h.mutex.Lock()
+	h.clients[queue] = struct{}{}
+	h.mutex.Unlock()
+	defer func() {
+		h.mutex.Lock()
+		delete(h.clients, queue)
+		h.mutex.Unlock()
+		conn.Close()
+	}()
+
+	// Notice the client hanging up even when no events are flowing
+	closed := make(chan struct{})
+	go func() {
+		buf := make([]byte, 64)
+		for {
+			if _, err := conn.Read(buf); err != nil {
+				close(closed)
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case line := <-queue:
+			if _, err := conn.Write(line); err != nil {
+				return
+			}
+		case <-closed:
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// emitEvent sends event to the event socket's clients, if any.
+func emitEvent(event Event) {
+	if events == nil {
+		return
+	}
+	event.Time = time.Now()
+	line, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	line = append(line, '\n')
+
+	events.mutex.Lock()
+	defer events.mutex.Unlock()
+	for queue := range events.clients {
+		select {
+		case queue <- line:
+		default:
+		}
+	}
+}
+
+// emitTransfer reports the bytes relayed by a finished connection.
+func emitTransfer(config *ForwardConfig, client net.Addr, target string, start time.Time, sent, received int64) {
+	if events == nil {
+		return
+	}
+	emitEvent(Event{
+		Type:     "bytes",
+		Server:   config.ActiveServer(),
+		Forward:  config.SectionName,
+		Client:   client.String(),
+		Target:   target,
+		Sent:     sent,
+		Received: received,
+		Duration: time.Since(start).Seconds(),
+		Labels:   config.Labels,
+	})
+}
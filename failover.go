@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// getForwardConnection returns a shared connection to the first of the
+// forward's servers that can be reached, trying them in configured order.
+func getForwardConnection(config *ForwardConfig) (*ssh.Client, string, error) {
+	var failures []string
+	for _, serverName := range config.ServerNames {
+		if lookupServer(serverName) == nil {
+			continue
+		}
+
+		conn, err := connManager.GetConnection(serverName)
+		if err != nil {
+			if len(config.ServerNames) > 1 {
+				log.Printf("Server %s unavailable for %s: %v", serverName, config.SectionName, err)
+			}
+			failures = append(failures, fmt.Sprintf("%s: %v", serverName, err))
+			continue
+		}
+
+		if previous := config.ActiveServer(); previous != "" && previous != serverName {
+			log.Printf("Forward %s failed over from %s to %s", config.SectionName, previous, serverName)
+		}
+		config.setActiveServer(serverName, conn)
+		return conn, serverName, nil
+	}
+
+	config.setActiveServer("", nil)
+	if len(failures) == 0 {
+		return nil, "", fmt.Errorf("no server configuration found for %s", config.ServerName)
+	}
+	return nil, "", fmt.Errorf("all servers failed (%s)", strings.Join(failures, "; "))
+}
+
+// ActiveServer returns the server currently carrying the forward, or "" when
+// it is not connected.
+func (fc *ForwardConfig) ActiveServer() string {
+	fc.activeMutex.Lock()
+	defer fc.activeMutex.Unlock()
+	return fc.activeServer
+}
+
+// ActiveConnection returns the connection the forward is using, or nil.
+func (fc *ForwardConfig) ActiveConnection() *ssh.Client {
+	fc.activeMutex.Lock()
+	defer fc.activeMutex.Unlock()
+	return fc.activeConn
+}
+
+func (fc *ForwardConfig) setActiveServer(serverName string, conn *ssh.Client) {
+	fc.activeMutex.Lock()
+	defer fc.activeMutex.Unlock()
+	fc.activeServer = serverName
+	fc.activeConn = conn
+}
+
+// ListenAddr returns the address the forward is currently listening on, or ""
+// when its listener is not open.
+func (fc *ForwardConfig) ListenAddr() string {
+	fc.activeMutex.Lock()
+	defer fc.activeMutex.Unlock()
+	return fc.listenAddr
+}
+
+func (fc *ForwardConfig) setListenAddr(addr string) {
+	fc.activeMutex.Lock()
+	defer fc.activeMutex.Unlock()
+	fc.listenAddr = addr
+}
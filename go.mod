@@ -4,7 +4,11 @@ go 1.21.6
 
 require (
 	github.com/getlantern/systray v1.2.2
+	github.com/gorilla/websocket v1.5.3
+	github.com/kevinburke/ssh_config v1.2.0
 	golang.org/x/crypto v0.27.0
+	golang.org/x/sys v0.25.0
+	golang.org/x/term v0.24.0
 	gopkg.in/ini.v1 v1.67.0
 )
 
@@ -18,5 +22,4 @@ require (
 	github.com/go-stack/stack v1.8.0 // indirect
 	github.com/oxtoacart/bpool v0.0.0-20190530202638-03653db5a59c // indirect
 	github.com/stretchr/testify v1.9.0 // indirect
-	golang.org/x/sys v0.25.0 // indirect
 )
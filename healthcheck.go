@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Time a single health check may take
+const healthCheckTimeout = 10 * time.Second
+
+// healthState is the latest health check result of a forward
+type healthState struct {
+	mutex     sync.Mutex
+	checkedAt time.Time
+	ok        bool
+	rtt       time.Duration
+	err       string
+}
+
+// HealthResult is a snapshot of a forward's latest health check
+type HealthResult struct {
+	CheckedAt time.Time
+	OK        bool
+	RTT       time.Duration
+	Error     string
+}
+
+// Health returns the forward's latest health check, with a zero CheckedAt
+// when none has completed.
+func (fc *ForwardConfig) Health() HealthResult {
+	fc.health.mutex.Lock()
+	defer fc.health.mutex.Unlock()
+	return HealthResult{CheckedAt: fc.health.checkedAt, OK: fc.health.ok, RTT: fc.health.rtt, Error: fc.health.err}
+}
+
+// record stores a check result and reports whether it changed the forward
+// between healthy and failing.
+func (h *healthState) record(rtt time.Duration, err error) bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	changed := h.checkedAt.IsZero() || h.ok != (err == nil)
+	h.checkedAt = time.Now()
+	h.ok = err == nil
+	h.rtt = rtt
+	h.err = ""
+	if err != nil {
+		h.err = err.Error()
+	}
+	return changed
+}
+
+// runHealthCheck probes the forward's listener every healthCheck interval
+// until ctx is done: a local forward through to remoteIP:remotePort, a socks5
+// forward with a CONNECT to healthCheckTarget.
+func runHealthCheck(ctx context.Context, config *ForwardConfig, commonConfig *CommonConfig) {
+	ticker := time.NewTicker(config.HealthCheck)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+
+		start := time.Now()
+		var err error
+		if config.Direction == "socks5" {
+			err = probeSocks5(ctx, config)
+		} else {
+			err = probeLocal(ctx, config)
+		}
+		rtt := time.Since(start)
+		// A probe cut short by the forward stopping says nothing
+		if ctx.Err() != nil {
+			return
+		}
+
+		changed := config.health.record(rtt, err)
+		switch {
+		case err != nil && (changed || commonConfig.Debug):
+			log.Printf("Health check of %s failed: %v", config.SectionName, err)
+		case err == nil && (changed || commonConfig.Debug):
+			log.Printf("Health check of %s passed in %s", config.SectionName, rtt.Round(time.Millisecond))
+		}
+	}
+}
+
+// dialListener connects to the forward's own listener like a client would.
+func dialListener(ctx context.Context, config *ForwardConfig) (net.Conn, error) {
+	addr := config.ListenAddr()
+	if addr == "" {
+		return nil, fmt.Errorf("not listening")
+	}
+	// A wildcard listener is probed over loopback
+	host, port, _ := net.SplitHostPort(addr)
+	if ip := net.ParseIP(host); ip != nil && ip.IsUnspecified() {
+		addr = net.JoinHostPort("127.0.0.1", port)
+	}
+
+	dialer := &net.Dialer{Timeout: healthCheckTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if config.tlsConfig != nil {
+		// The listener's certificate is ours, there is nothing to verify
+		conn = tls.Client(conn, &tls.Config{InsecureSkipVerify: true})
+	}
+	conn.SetDeadline(time.Now().Add(healthCheckTimeout))
+	return conn, nil
+}
+
+// probeLocal connects through a local forward and sends healthCheckSend, if
+// set. The forward is healthy when the target answers, or stays connected
+// and silent until the timeout; the tunnel closing the connection means it
+// could not reach the target.
+func probeLocal(ctx context.Context, config *ForwardConfig) error {
+	conn, err := dialListener(ctx, config)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if config.HealthCheckSend != "" {
+		if _, err := io.WriteString(conn, config.HealthCheckSend); err != nil {
+			return fmt.Errorf("failed to send probe: %v", err)
+		}
+	}
+	_, err = conn.Read(make([]byte, 1))
+	var netErr net.Error
+	if err == nil || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return nil
+	}
+	if err == io.EOF {
+		return fmt.Errorf("connection closed before the target answered")
+	}
+	return err
+}
+
+// probeSocks5 asks a socks5 forward to CONNECT to healthCheckTarget, logging
+// in with the forward's socks5User when it has one.
+func probeSocks5(ctx context.Context, config *ForwardConfig) error {
+	conn, err := dialListener(ctx, config)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	method := byte(0x00)
+	if config.Socks5User != "" {
+		method = 0x02
+	}
+	if _, err := conn.Write([]byte{0x05, 0x01, method}); err != nil {
+		return err
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return fmt.Errorf("no method reply: %v", err)
+	}
+	if reply[1] != method {
+		return fmt.Errorf("authentication method refused")
+	}
+	if method == 0x02 {
+		auth := []byte{0x01, byte(len(config.Socks5User))}
+		auth = append(auth, config.Socks5User...)
+		auth = append(auth, byte(len(config.Socks5Pass)))
+		auth = append(auth, config.Socks5Pass...)
+		if _, err := conn.Write(auth); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(conn, reply); err != nil || reply[1] != 0x00 {
+			return fmt.Errorf("authentication failed")
+		}
+	}
+
+	host, portString, _ := net.SplitHostPort(config.HealthCheckTarget)
+	port, _ := strconv.Atoi(portString)
+	request := []byte{0x05, 0x01, 0x00, 0x03, byte(len(host))}
+	request = append(request, host...)
+	request = append(request, byte(port>>8), byte(port))
+	if _, err := conn.Write(request); err != nil {
+		return err
+	}
+
+	// Only the reply code matters, the bound address after it is not read
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return fmt.Errorf("no CONNECT reply: %v", err)
+	}
+	if header[1] != 0x00 {
+		return fmt.Errorf("CONNECT to %s failed with reply %d", config.HealthCheckTarget, header[1])
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Time a preConnectCommand or postConnectCommand may run before its session
+// is closed
+const forwardCommandTimeout = time.Minute
+
+// runForwardCommand runs command in a session on conn for the forward and
+// logs its output. kind names the option the command comes from.
+func runForwardCommand(conn *ssh.Client, config *ForwardConfig, kind, command string) error {
+	session, err := conn.NewSession()
+	if err != nil {
+		return fmt.Errorf("failed to open session: %v", err)
+	}
+	defer session.Close()
+
+	// Sessions have no deadline, so a hung command is ended by closing it
+	timer := time.AfterFunc(forwardCommandTimeout, func() { session.Close() })
+	log.Printf("Running %s for %s: %s", kind, config.SectionName, command)
+	output, err := session.CombinedOutput(command)
+	timedOut := !timer.Stop()
+
+	for _, line := range strings.Split(strings.TrimRight(string(output), "\n"), "\n") {
+		if line != "" {
+			log.Printf("%s %s: %s", config.SectionName, kind, line)
+		}
+	}
+	if timedOut {
+		return fmt.Errorf("timed out after %s", forwardCommandTimeout)
+	}
+	return err
+}
+
+// runPostConnectCommand runs the forward's postConnectCommand once the
+// forward has stopped on conn. A failure is only logged, as there is nothing
+// left to abort.
+func runPostConnectCommand(conn *ssh.Client, config *ForwardConfig) {
+	if err := runForwardCommand(conn, config, "postConnectCommand", config.PostConnectCommand); err != nil {
+		log.Printf("Warning: postConnectCommand for %s failed: %v", config.SectionName, err)
+	}
+}
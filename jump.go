@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// jumpHost is one hop of a proxyJump chain
+type jumpHost struct {
+	User string
+	Host string
+	Port string
+}
+
+// parseProxyJump parses an OpenSSH ProxyJump value: a comma-separated list of
+// [user@]host[:port] hops, optionally written as ssh:// URIs. Port is left
+// empty when not given.
+func parseProxyJump(value string) ([]jumpHost, error) {
+	var hops []jumpHost
+	for _, item := range splitList(value) {
+		var hop jumpHost
+		rest := strings.TrimPrefix(item, "ssh://")
+		if at := strings.LastIndex(rest, "@"); at >= 0 {
+			hop.User, rest = rest[:at], rest[at+1:]
+		}
+		if host, port, err := net.SplitHostPort(rest); err == nil {
+			hop.Host, hop.Port = host, port
+		} else {
+			hop.Host = strings.Trim(rest, "[]")
+		}
+		if hop.Host == "" {
+			return nil, fmt.Errorf("invalid proxyJump hop %q", item)
+		}
+		if hop.Port != "" {
+			port, err := normalizePort(hop.Port)
+			if err != nil {
+				return nil, fmt.Errorf("invalid proxyJump hop %q: %v", item, err)
+			}
+			hop.Port = port
+		}
+		hops = append(hops, hop)
+	}
+	return hops, nil
+}
+
+// dialServer connects to a server, going through its proxyJump hops first
+// when configured. A hop naming a server section uses that section's
+// credentials and algorithms; other hops log in with the target's password.
+// The hop connections are closed when the returned client closes.
+func dialServer(serverConfig *ServerConfig, sshConfig *ssh.ClientConfig) (*ssh.Client, error) {
+	target := net.JoinHostPort(serverConfig.Server, serverConfig.Port)
+	if len(serverConfig.ProxyJump) == 0 {
+		return dialFirstHop(serverConfig, target, sshConfig)
+	}
+
+	var chain []*ssh.Client
+	closeChain := func() {
+		for i := len(chain) - 1; i >= 0; i-- {
+			chain[i].Close()
+		}
+	}
+
+	for _, hop := range serverConfig.ProxyJump {
+		address, hopConfig, hopAuth, err := jumpTarget(hop, sshConfig)
+		if err != nil {
+			closeChain()
+			return nil, fmt.Errorf("jump host %s: %v", hop.Host, err)
+		}
+		var client *ssh.Client
+		if len(chain) == 0 {
+			client, err = dialFirstHop(serverConfig, address, hopConfig)
+		} else {
+			client, err = dialThrough(chain, address, hopConfig)
+		}
+		if hopAuth != nil {
+			hopAuth.Close()
+		}
+		if err != nil {
+			closeChain()
+			return nil, fmt.Errorf("jump host %s: %v", address, err)
+		}
+		log.Printf("Connected to jump host %s", address)
+		chain = append(chain, client)
+	}
+
+	client, err := dialThrough(chain, target, sshConfig)
+	if err != nil {
+		closeChain()
+		return nil, err
+	}
+
+	go func() {
+		client.Wait()
+		closeChain()
+	}()
+	return client, nil
+}
+
+// dialFirstHop opens an SSH client to address over the server's transport:
+// its DialFunc when set, plain TCP to each of the host's addresses otherwise.
+func dialFirstHop(serverConfig *ServerConfig, address string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	var netConn net.Conn
+	var err error
+	if serverConfig.DialFunc == nil {
+		netConn, err = dialAddresses(serverConfig, address, config.Timeout)
+	} else {
+		netConn, err = serverConfig.DialFunc("tcp", address)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return newClient(netConn, address, config)
+}
+
+// dialThrough opens an SSH client to address, tunneled through the last
+// client of chain.
+func dialThrough(chain []*ssh.Client, address string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	netConn, err := chain[len(chain)-1].Dial("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	return newClient(netConn, address, config)
+}
+
+// newClient runs the SSH handshake over an established connection.
+func newClient(netConn net.Conn, address string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	conn, chans, reqs, err := ssh.NewClientConn(netConn, address, config)
+	if err != nil {
+		netConn.Close()
+		return nil, err
+	}
+	return ssh.NewClient(conn, chans, reqs), nil
+}
+
+// jumpTarget returns the address and client config for a hop, based on the
+// target's config. A hop naming a server section also returns its own auth,
+// to be closed once the hop is connected.
+func jumpTarget(hop jumpHost, sshConfig *ssh.ClientConfig) (string, *ssh.ClientConfig, *sshAuth, error) {
+	var auth *sshAuth
+	hopConfig := *sshConfig
+	hopConfig.Config = ssh.Config{}
+	host, port := hop.Host, hop.Port
+
+	if section := lookupServer(hop.Host); section != nil {
+		host = section.Server
+		if port == "" {
+			port = section.Port
+		}
+		hopConfig.User = section.User
+		var err error
+		if auth, err = newSSHAuth(section); err != nil {
+			return "", nil, nil, err
+		}
+		hopConfig.Auth = auth.Methods
+		hopConfig.Config = algorithmConfig(section)
+		hopConfig.BannerCallback = bannerCallback(hop.Host, section)
+	}
+
+	if port == "" {
+		port = "22"
+	}
+	if hop.User != "" {
+		hopConfig.User = hop.User
+	}
+	return net.JoinHostPort(host, port), &hopConfig, auth, nil
+}
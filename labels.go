@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Label names allowed by Prometheus
+var labelNamePattern = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// Labels spf sets itself on forward metrics
+var reservedLabels = map[string]bool{"forward": true, "direction": true}
+
+// parseLabels parses the labels option, a comma-separated list of name=value
+// pairs such as "env=prod, team=payments".
+func parseLabels(value string) (map[string]string, error) {
+	items := splitList(value)
+	if len(items) == 0 {
+		return nil, nil
+	}
+	labels := make(map[string]string)
+	for _, item := range items {
+		name, labelValue, ok := strings.Cut(item, "=")
+		name = strings.TrimSpace(name)
+		if !ok {
+			return nil, fmt.Errorf("invalid label %q (expected name=value)", item)
+		}
+		if !labelNamePattern.MatchString(name) || strings.HasPrefix(name, "__") {
+			return nil, fmt.Errorf("invalid label name %q (letters, digits and underscores, not starting with a digit or __)", name)
+		}
+		if reservedLabels[name] {
+			return nil, fmt.Errorf("label name %q is reserved", name)
+		}
+		if _, exists := labels[name]; exists {
+			return nil, fmt.Errorf("duplicate label %q", name)
+		}
+		labels[name] = strings.TrimSpace(labelValue)
+	}
+	return labels, nil
+}
+
+// sortedLabelNames returns the names of labels in order, for stable output.
+func sortedLabelNames(labels map[string]string) []string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// formatLabels renders labels for log lines, e.g. " labels=env=prod,team=payments",
+// or "" when there are none.
+func formatLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	pairs := make([]string, 0, len(labels))
+	for _, name := range sortedLabelNames(labels) {
+		pairs = append(pairs, name+"="+labels[name])
+	}
+	return " labels=" + strings.Join(pairs, ",")
+}
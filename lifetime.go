@@ -0,0 +1,87 @@
+package main
+
+import (
+	"log"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// trackChannel counts channel as open on conn until it is closed, so
+// connection rotation can wait for the connection to be idle and pools can
+// pick their least busy connection.
+func (cm *ConnectionManager) trackChannel(conn *ssh.Client, channel net.Conn) *limitedConn {
+	cm.mutex.RLock()
+	count := cm.channels[conn]
+	cm.mutex.RUnlock()
+	if count == nil {
+		return &limitedConn{Conn: channel, release: func() {}}
+	}
+
+	atomic.AddInt64(count, 1)
+	return &limitedConn{Conn: channel, release: func() { atomic.AddInt64(count, -1) }}
+}
+
+// activeChannels returns the number of open forwarded channels on conn.
+func (cm *ConnectionManager) activeChannels(conn *ssh.Client) int64 {
+	cm.mutex.RLock()
+	defer cm.mutex.RUnlock()
+	if count := cm.channels[conn]; count != nil {
+		return atomic.LoadInt64(count)
+	}
+	return 0
+}
+
+// logChannels logs the number of open channels on conn in debug mode, to
+// show how busy a connection is, e.g. when sshd starts refusing channels.
+func (cm *ConnectionManager) logChannels(serverName string, conn *ssh.Client) {
+	if serverConfig := lookupServer(poolServerName(serverName)); serverConfig != nil && serverConfig.debug {
+		log.Printf("SSH connection for server %s has %d open channels", serverName, cm.activeChannels(conn))
+	}
+}
+
+// shouldRotate reports whether the connection has outlived its server's
+// maxConnectionLifetime and is idle, so it can be replaced without breaking
+// transfers. A busy connection is checked again on the next monitor tick.
+func (cm *ConnectionManager) shouldRotate(serverName string, conn *ssh.Client, connectedAt time.Time) bool {
+	serverConfig := lookupServer(poolServerName(serverName))
+	if serverConfig == nil || serverConfig.MaxLifetime == 0 {
+		return false
+	}
+	age := time.Since(connectedAt)
+	if age < serverConfig.MaxLifetime {
+		return false
+	}
+	if active := cm.activeChannels(conn); active > 0 {
+		log.Printf("SSH connection for server %s is due for rotation but has %d active channels, retrying later", serverName, active)
+		return false
+	}
+	log.Printf("Rotating SSH connection for server %s after %s", serverName, age.Round(time.Second))
+	return true
+}
+
+// closeIdle closes every connection without open channels, so forwards
+// reconnect on fresh ones, e.g. after a network change left them stale.
+// Connections carrying transfers are left alone.
+func (cm *ConnectionManager) closeIdle() (closed, busy int) {
+	cm.mutex.Lock()
+	defer cm.mutex.Unlock()
+	for key, conn := range cm.connections {
+		if conn == nil {
+			continue
+		}
+		if count := cm.channels[conn]; count != nil && atomic.LoadInt64(count) > 0 {
+			busy++
+			continue
+		}
+		cm.markLost(conn)
+		delete(cm.connections, key)
+		cm.setState(key, stateReconnecting)
+		log.Printf("Closed idle SSH connection for server: %s", key)
+		emitEvent(Event{Type: "disconnect", Server: key})
+		closed++
+	}
+	return closed, busy
+}
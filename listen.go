@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// portRange is an inclusive range of TCP ports
+type portRange struct {
+	first, last int
+}
+
+func (r *portRange) String() string {
+	return fmt.Sprintf("%d-%d", r.first, r.last)
+}
+
+// parseLocalPortRange reads localPortRange, e.g. 1080-1090. localPort then
+// defaults to the first port of the range and must lie within it.
+func parseLocalPortRange(fc *ForwardConfig, value string) error {
+	if value == "" {
+		return nil
+	}
+	switch fc.Direction {
+	case "local", "socks5", "sni-route":
+	default:
+		return fmt.Errorf("localPortRange only applies to forwards with a local listener in %s", fc.SectionName)
+	}
+	firstValue, lastValue, ok := strings.Cut(value, "-")
+	first, err1 := strconv.Atoi(strings.TrimSpace(firstValue))
+	last, err2 := strconv.Atoi(strings.TrimSpace(lastValue))
+	if !ok || err1 != nil || err2 != nil || first < 1 || last > 65535 || first > last {
+		return fmt.Errorf("invalid localPortRange %q for %s (expected first-last, e.g. 1080-1090)", value, fc.SectionName)
+	}
+	if fc.LocalPort == "" {
+		fc.LocalPort = strconv.Itoa(first)
+	}
+	if port, _ := strconv.Atoi(fc.LocalPort); port < first || port > last {
+		return fmt.Errorf("localPort %s for %s is outside localPortRange %s", fc.LocalPort, fc.SectionName, value)
+	}
+	fc.LocalPortRange = &portRange{first, last}
+	return nil
+}
+
+// listenLocal opens the local listener of a local, socks5 or sni-route
+// forward on localIP:localPort, sharing the port with other sockets that
+// set SO_REUSEPORT when the forward's reusePort is set. With localPortRange,
+// the other ports of the range are tried in turn when localPort cannot be
+// bound.
+func listenLocal(config *ForwardConfig) (net.Listener, error) {
+	listenConfig := &net.ListenConfig{}
+	if config.ReusePort && reusePortSupported {
+		listenConfig.Control = reusePortControl
+	}
+	listener, err := listenConfig.Listen(context.Background(), "tcp", net.JoinHostPort(config.LocalIP, config.LocalPort))
+	if err == nil || config.LocalPortRange == nil {
+		return listener, err
+	}
+
+	for port := config.LocalPortRange.first; port <= config.LocalPortRange.last; port++ {
+		if strconv.Itoa(port) == config.LocalPort {
+			continue
+		}
+		listener, rangeErr := listenConfig.Listen(context.Background(), "tcp", net.JoinHostPort(config.LocalIP, strconv.Itoa(port)))
+		if rangeErr == nil {
+			log.Printf("Port %s for %s is unavailable (%v), listening on port %d of localPortRange instead",
+				config.LocalPort, config.SectionName, err, port)
+			return listener, nil
+		}
+	}
+	return nil, fmt.Errorf("%v (and no other port of localPortRange %d-%d is free)", err, config.LocalPortRange.first, config.LocalPortRange.last)
+}
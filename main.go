@@ -5,57 +5,55 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"fmt"
-	"io"
 	"log"
 	"net"
+	"os"
+	"os/signal"
 	"sync"
+	"syscall"
 	"time"
 
 	"golang.org/x/crypto/ssh"
-	"gopkg.in/ini.v1"
 )
 
-type ServerConfig struct {
-	Server   string
-	User     string
-	Password string
-	Port     string
-}
-
-type CommonConfig struct {
-	Debug bool
-}
-
-type ForwardConfig struct {
-	SectionName string
-	ServerName  string
-	RemoteIP    string
-	RemotePort  string
-	LocalIP     string
-	LocalPort   string
-	Direction   string
-	SSHConfig   *ServerConfig
-	// SOCKS5 authentication
-	Socks5User string
-	Socks5Pass string
-}
-
 // Connection manager for shared SSH connections
 type ConnectionManager struct {
 	connections map[string]*ssh.Client
+	stats       map[string]*connectionStats
+	lost        map[*ssh.Client]chan struct{}
+	channels    map[*ssh.Client]*int64
+	connected   chan struct{} // closed and replaced on every new connection
 	mutex       sync.RWMutex
+	dials       map[string]*pendingDial // in-flight dials, by connection key
+	dialMutex   sync.Mutex
 	ctx         context.Context
 	cancel      context.CancelFunc
 }
 
+// A forward section and its running state
+type forwardState struct {
+	config *ForwardConfig
+	cancel context.CancelFunc // nil when the forward is stopped
+	done   chan struct{}
+}
+
 var (
 	connManager *ConnectionManager
-	servers     map[string]*ServerConfig
 	ctx         context.Context
 	cancel      context.CancelFunc
+
+	// Forwards from the currently loaded configuration, by section name
+	forwards      map[string]*forwardState
+	forwardOrder  []string
+	forwardsMutex sync.Mutex
 )
 
+// Config file in use, resolved at startup and reused on reload
+var configPath string
+
 func main() {
 	// Initialize context for graceful shutdown
 	ctx, cancel = context.WithCancel(context.Background())
@@ -64,87 +62,297 @@ func main() {
 	// Initialize connection manager
 	connManager = &ConnectionManager{
 		connections: make(map[string]*ssh.Client),
+		stats:       make(map[string]*connectionStats),
+		lost:        make(map[*ssh.Client]chan struct{}),
+		channels:    make(map[*ssh.Client]*int64),
+		connected:   make(chan struct{}),
+		dials:       make(map[string]*pendingDial),
 		ctx:         ctx,
 		cancel:      cancel,
 	}
 
-	cfg, err := ini.Load("config.ini")
+	configFlag := flag.String("config", "", "path to the config file (default: $SPF_CONFIG or the first of ./config.ini, $XDG_CONFIG_HOME/spf/config.ini, /etc/spf/config.ini)")
+	validateOnly := flag.Bool("validate", false, "validate the config file, print a summary and exit without connecting")
+	dumpConfig := flag.Bool("dump-config", false, "print the effective configuration as JSON, with passwords redacted, and exit")
+	stdioSection := flag.String("stdio", "", "connect stdin/stdout through the given stdio forward section and exit, e.g. as an ssh ProxyCommand (host and port may follow)")
+	flag.Parse()
+
+	configPath = findConfigFile(*configFlag)
+	config, err := loadConfig(configPath)
 	if err != nil {
-		log.Fatalf("Failed to load config file: %v", err)
+		log.Fatalf("Failed to load config file %s: %v", configPath, err)
+	}
+	if *dumpConfig {
+		if err := writeConfigJSON(os.Stdout, config); err != nil {
+			log.Fatalf("Failed to write configuration: %v", err)
+		}
+		cancel()
+		os.Exit(0)
+	}
+	if *stdioSection != "" {
+		code := runStdio(config, *stdioSection, flag.Args())
+		cancel()
+		os.Exit(code)
+	}
+	log.Printf("Loaded configuration from %s", configPath)
+
+	if *validateOnly {
+		printConfigSummary(os.Stdout, config)
+		errs := validateConfig(config)
+		for _, err := range errs {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		}
+		if len(errs) > 0 {
+			os.Exit(1)
+		}
+		fmt.Println("Configuration is valid")
+		return
 	}
 
-	// Parse common configuration
-	var commonConfig CommonConfig
-	if cfg.HasSection("common") {
-		commonSection := cfg.Section("common")
-		commonConfig.Debug = commonSection.Key("debug").MustBool(false)
+	if errs := validateConfig(config); len(errs) > 0 {
+		log.Fatalf("Invalid configuration: %v", errors.Join(errs...))
+	}
+	if err := promptPasswords(config, true); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+
+	// The PID file is only read at startup; changing it requires a restart
+	pidFile := config.Common.PidFile
+	if pidFile != "" {
+		if err := os.WriteFile(pidFile, []byte(fmt.Sprintf("%d\n", os.Getpid())), 0644); err != nil {
+			log.Fatalf("Failed to write PID file %s: %v", pidFile, err)
+		}
+	}
+
+	// The event socket is only opened once; changing eventSocket requires a restart
+	if config.Common.EventSocket != "" {
+		if err := startEventSocket(config.Common.EventSocket); err != nil {
+			log.Fatalf("Failed to start event socket: %v", err)
+		}
+	}
+
+	startForwards(config)
+
+	// The poller is only started once; changing configURL or
+	// configPollInterval requires a restart
+	if config.Common.ConfigURL != "" && config.Common.ConfigPollInterval > 0 {
+		go pollRemoteConfig(ctx, config.Common.ConfigURL, config.Common.ConfigTokenEnv, config.Common.ConfigPollInterval, func() {
+			reloadConfig()
+		})
 	}
 
-	servers = make(map[string]*ServerConfig)
-	var forwardConfigs []*ForwardConfig
+	// The control API is only started once; changing controlListen requires a restart
+	if config.Common.ControlListen != "" {
+		if err := startControlServer(config.Common.ControlListen); err != nil {
+			log.Fatalf("Failed to start control API: %v", err)
+		}
+	}
 
-	for _, section := range cfg.Sections() {
-		if section.Name() == "DEFAULT" || section.Name() == "common" {
+	// Wait for shutdown or reload signals
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGUSR1)
+	for sig := range signals {
+		if sig == syscall.SIGHUP {
+			log.Printf("Received SIGHUP, reloading configuration...")
+			reloadConfig()
 			continue
 		}
+		if sig == syscall.SIGUSR1 {
+			closed, busy := connManager.closeIdle()
+			log.Printf("Received SIGUSR1, closed %d idle SSH connections, kept %d busy ones", closed, busy)
+			continue
+		}
+		log.Printf("Received %v, shutting down...", sig)
+		break
+	}
 
-		if section.HasKey("user") && section.HasKey("password") {
-			port := section.Key("port").String()
-			if port == "" {
-				port = "22" // Default SSH port
-			}
-			servers[section.Name()] = &ServerConfig{
-				Server:   section.Key("server").String(),
-				User:     section.Key("user").String(),
-				Password: section.Key("password").String(),
-				Port:     port,
-			}
-		} else if section.HasKey("server") && section.HasKey("direction") {
-			forwardConfig := &ForwardConfig{
-				SectionName: section.Name(),
-				ServerName:  section.Key("server").String(),
-				RemoteIP:    section.Key("remoteIP").String(),
-				RemotePort:  section.Key("remotePort").String(),
-				LocalIP:     section.Key("localIP").String(),
-				LocalPort:   section.Key("localPort").String(),
-				Direction:   section.Key("direction").String(),
-				Socks5User:  section.Key("socks5User").String(),
-				Socks5Pass:  section.Key("socks5Pass").String(),
-			}
-			forwardConfigs = append(forwardConfigs, forwardConfig)
+	cancel()
+	connManager.CloseAll()
+
+	if pidFile != "" {
+		os.Remove(pidFile)
+	}
+	if config.Common.EventSocket != "" {
+		os.Remove(config.Common.EventSocket)
+	}
+}
+
+func startForwards(config *Config) {
+	forwardsMutex.Lock()
+	defer forwardsMutex.Unlock()
+
+	publishServers(config.Servers)
+	liveCommon.Store(config.Common)
+	forwards = make(map[string]*forwardState)
+	forwardOrder = nil
+
+	for _, fc := range config.Forwards {
+		if !fc.runsInBackground() {
+			continue
 		}
+		state := &forwardState{config: fc}
+		forwards[fc.SectionName] = state
+		forwardOrder = append(forwardOrder, fc.SectionName)
+		state.start()
+	}
+}
+
+// start runs the forward's reconnect loop. Callers hold forwardsMutex.
+func (f *forwardState) start() {
+	if f.cancel != nil {
+		return
+	}
+
+	var forwardCtx context.Context
+	forwardCtx, f.cancel = context.WithCancel(ctx)
+	f.done = make(chan struct{})
+
+	go func(done chan struct{}) {
+		defer close(done)
+		runScheduled(forwardCtx, f.config, currentCommon(), handleConnection)
+	}(f.done)
+}
+
+// stop cancels the forward and waits for its listener to close. Callers
+// hold forwardsMutex.
+func (f *forwardState) stop() {
+	if f.cancel == nil {
+		return
+	}
+
+	f.cancel()
+	<-f.done
+	f.cancel = nil
+}
+
+func startForward(name string) error {
+	forwardsMutex.Lock()
+	defer forwardsMutex.Unlock()
+
+	state, ok := forwards[name]
+	if !ok {
+		return fmt.Errorf("forward not found: %s", name)
+	}
+	state.start()
+	log.Printf("Started forward %s", name)
+	return nil
+}
+
+func stopForward(name string) error {
+	forwardsMutex.Lock()
+	defer forwardsMutex.Unlock()
+
+	state, ok := forwards[name]
+	if !ok {
+		return fmt.Errorf("forward not found: %s", name)
+	}
+	state.stop()
+	log.Printf("Stopped forward %s", name)
+	return nil
+}
+
+func reloadConfig() error {
+	config, err := loadConfig(configPath)
+	if err == nil {
+		err = errors.Join(validateConfig(config)...)
+	}
+	if err == nil {
+		err = promptPasswords(config, false)
+	}
+	if err != nil {
+		log.Printf("Failed to reload config file, keeping current configuration: %v", err)
+		return err
 	}
 
-	for _, fc := range forwardConfigs {
-		if sshConfig, ok := servers[fc.ServerName]; ok {
-			fc.SSHConfig = sshConfig
-			go handleConnection(fc, &commonConfig)
+	forwardsMutex.Lock()
+	defer forwardsMutex.Unlock()
+
+	// Servers whose connection settings are unchanged keep their connections
+	// and state
+	changedServers := make(map[string]bool)
+	for name, old := range loadedServers() {
+		if sc, ok := config.Servers[name]; ok && !connectionSettingsChanged(sc, old) {
+			// The new config brings its other settings, and those following
+			// [common], along
+			carryServerState(sc, old)
 		} else {
-			log.Printf("Warning: No server configuration found for %s", fc.SectionName)
+			changedServers[name] = true
 		}
 	}
+	relinkServers(config)
 
-	// Keep the main goroutine running
-	select {}
+	// Only forwards whose section or servers changed are restarted
+	kept := make(map[string]*forwardState)
+	for _, fc := range config.Forwards {
+		if state, ok := forwards[fc.SectionName]; ok && fc.SSHConfig != nil &&
+			state.config.fingerprint == fc.fingerprint && !usesServer(state.config, changedServers) {
+			kept[fc.SectionName] = state
+		}
+	}
+	var stopping []*forwardState
+	for name, state := range forwards {
+		if kept[name] == nil && state.cancel != nil {
+			state.cancel()
+			stopping = append(stopping, state)
+		}
+	}
+	for name := range changedServers {
+		connManager.closeServer(name)
+	}
+	for _, state := range stopping {
+		state.stop()
+	}
+
+	// [common] settings such as debug apply to the kept forwards' new
+	// connections
+	liveCommon.Store(config.Common)
+
+	publishServers(config.Servers)
+	forwards = make(map[string]*forwardState)
+	forwardOrder = nil
+	restarted := 0
+	for _, fc := range config.Forwards {
+		if !fc.runsInBackground() {
+			continue
+		}
+		state := kept[fc.SectionName]
+		if state == nil {
+			state = &forwardState{config: fc}
+			state.start()
+			restarted++
+		}
+		forwards[fc.SectionName] = state
+		forwardOrder = append(forwardOrder, fc.SectionName)
+	}
+
+	log.Printf("Configuration reloaded from %s: %d forwards started or restarted, %d unchanged", configPath, restarted, len(kept))
+	return nil
 }
 
-func handleConnection(config *ForwardConfig, commonConfig *CommonConfig) {
+func handleConnection(ctx context.Context, config *ForwardConfig, commonConfig *CommonConfig) {
+	failures := 0
 	for {
 		select {
 		case <-ctx.Done():
 			return
 		default:
-			err := connectAndForward(config, commonConfig)
-			if err != nil {
-				log.Printf("Error in connection for %s: %v. Retrying in 30 seconds...", config.SectionName, err)
+			err := connectAndForward(ctx, config, commonConfig)
+			if err == nil {
+				failures = 0
+				continue
+			}
+			if ctx.Err() == nil {
+				failures++
+				delay := retryDelay(config, failures)
+				log.Printf("Error in connection for %s: %v. Retrying in %s...", config.SectionName, err, delay)
+				emitEvent(Event{Type: "error", Forward: config.SectionName, Server: config.ActiveServer(), Error: err.Error(), Labels: config.Labels})
 
 				// Remove the failed connection so it can be recreated
-				connManager.RemoveConnection(config.ServerName)
+				if conn := config.ActiveConnection(); conn != nil {
+					connManager.RemoveClient(conn)
+				}
 
-				select {
-				case <-time.After(30 * time.Second):
-					continue
-				case <-ctx.Done():
+				if !waitRetry(ctx, config, delay) {
 					return
 				}
 			}
@@ -152,24 +360,41 @@ func handleConnection(config *ForwardConfig, commonConfig *CommonConfig) {
 	}
 }
 
-func connectAndForward(config *ForwardConfig, commonConfig *CommonConfig) error {
-	// Get shared SSH connection
-	conn, err := connManager.GetConnection(config.ServerName)
+func connectAndForward(ctx context.Context, config *ForwardConfig, commonConfig *CommonConfig) error {
+	// Get shared SSH connection, failing over between the configured servers
+	conn, serverName, err := getForwardConnection(config)
 	if err != nil {
 		return fmt.Errorf("failed to get connection for %s: %v", config.ServerName, err)
 	}
 
-	log.Printf("Using shared connection to %s for %s", config.SSHConfig.Server, config.SectionName)
+	// Logged from the connection itself, as a reload may have removed the server since
+	log.Printf("Using shared connection to %s (%s) for %s", serverName, conn.RemoteAddr(), config.SectionName)
+
+	if config.PreConnectCommand != "" {
+		if err := runForwardCommand(conn, config, "preConnectCommand", config.PreConnectCommand); err != nil {
+			return fmt.Errorf("preConnectCommand failed for %s: %v", config.SectionName, err)
+		}
+	}
+	if config.PostConnectCommand != "" {
+		defer runPostConnectCommand(conn, config)
+	}
+
+	// Stop the forward as soon as the connection is found dead, so the
+	// reconnect loop restarts it on a fresh one
+	ctx, stop := connManager.connectionContext(ctx, conn)
+	defer stop()
 
 	switch config.Direction {
 	case "remote":
-		err = handleRemotePortForward(conn, config, commonConfig)
+		err = handleRemotePortForward(ctx, conn, config, commonConfig)
 	case "local":
-		err = handleLocalPortForward(conn, config, commonConfig)
+		err = handleLocalPortForward(ctx, conn, config, commonConfig)
 	case "socks5":
-		err = handleSocks5Proxy(conn, config, commonConfig)
+		err = handleSocks5Proxy(ctx, conn, config, commonConfig)
+	case "sni-route":
+		err = handleSNIRoute(ctx, conn, config, commonConfig)
 	case "reverse-socks5":
-		err = handleReverseSocks5Proxy(conn, config, commonConfig)
+		err = handleReverseSocks5Proxy(ctx, conn, config, commonConfig)
 	default:
 		return fmt.Errorf("invalid direction: %s", config.Direction)
 	}
@@ -177,90 +402,178 @@ func connectAndForward(config *ForwardConfig, commonConfig *CommonConfig) error
 	return err
 }
 
-func handleRemotePortForward(conn *ssh.Client, config *ForwardConfig, commonConfig *CommonConfig) error {
-	listener, err := conn.Listen("tcp", fmt.Sprintf("%s:%s", config.RemoteIP, config.RemotePort))
+func handleRemotePortForward(ctx context.Context, conn *ssh.Client, config *ForwardConfig, commonConfig *CommonConfig) error {
+	listener, err := listenRemotePorts(ctx, conn, config)
 	if err != nil {
 		return fmt.Errorf("failed to listen on remote server: %v", err)
 	}
 	defer listener.Close()
 
-	log.Printf("Listening on %s:%s for remote port forwarding", config.RemoteIP, config.RemotePort)
+	// Remember the bound address, which has the chosen port when 0 was configured
+	config.setListenAddr(listener.Addr().String())
+	defer config.setListenAddr("")
+
+	// Close the listener on shutdown or reload so Accept returns
+	stop := context.AfterFunc(ctx, func() { listener.Close() })
+	defer stop()
+
+	log.Printf("Listening on %s for remote port forwarding", listener.Addr())
 
+	workers := startWorkerPool(ctx, config)
 	for {
 		remoteConn, err := listener.Accept()
 		if err != nil {
+			// Listener closed by shutdown, reload or stop
+			if ctx.Err() != nil {
+				return nil
+			}
 			return fmt.Errorf("failed to accept connection: %v", err)
 		}
 
-		go handleForwardingConnection(remoteConn, config.LocalIP, config.LocalPort, commonConfig)
+		// [common] as of this connection; a reload publishes a new one
+		commonConfig := currentCommon()
+		channel := connManager.trackChannel(conn, remoteConn)
+		workers.run(ctx, channel, traceConnection(commonConfig, config, channel, func() {
+			handleForwardingConnection(channel, config, commonConfig)
+		}))
 	}
 }
 
-func handleLocalPortForward(conn *ssh.Client, config *ForwardConfig, commonConfig *CommonConfig) error {
-	listener, err := net.Listen("tcp", fmt.Sprintf("%s:%s", config.LocalIP, config.LocalPort))
+func handleLocalPortForward(ctx context.Context, conn *ssh.Client, config *ForwardConfig, commonConfig *CommonConfig) error {
+	listener, err := listenLocal(config)
 	if err != nil {
 		return fmt.Errorf("failed to listen on local address: %v", err)
 	}
+	listener = wrapListenerTLS(wrapListenerDSCP(wrapListenerRate(wrapListenerSource(listener, config), config), config), config)
 	defer listener.Close()
 
-	log.Printf("Listening on %s:%s for local port forwarding", config.LocalIP, config.LocalPort)
+	// Remember the bound address, which has the chosen port when 0 was configured
+	config.setListenAddr(listener.Addr().String())
+	defer config.setListenAddr("")
+
+	// Close the listener on shutdown or reload so Accept returns
+	stop := context.AfterFunc(ctx, func() { listener.Close() })
+	defer stop()
+
+	if config.tlsConfig != nil {
+		log.Printf("Listening on %s for local port forwarding (TLS)", listener.Addr())
+	} else {
+		log.Printf("Listening on %s for local port forwarding", listener.Addr())
+	}
+	if config.HealthCheck > 0 {
+		go runHealthCheck(ctx, config, commonConfig)
+	}
 
+	workers := startWorkerPool(ctx, config)
 	for {
 		localConn, err := listener.Accept()
 		if err != nil {
+			// Listener closed by shutdown, reload or stop
+			if ctx.Err() != nil {
+				return nil
+			}
 			return fmt.Errorf("failed to accept connection: %v", err)
 		}
 
-		go func() {
-			remoteConn, err := conn.Dial("tcp", fmt.Sprintf("%s:%s", config.RemoteIP, config.RemotePort))
+		// [common] as of this connection; a reload publishes a new one
+		commonConfig := currentCommon()
+		workers.run(ctx, localConn, traceConnection(commonConfig, config, localConn, func() {
+			// Terminate TLS before opening a channel, the remote end gets plaintext
+			if err := completeHandshake(ctx, localConn); err != nil {
+				connLogf(localConn, "TLS handshake with %s failed for %s: %v", localConn.RemoteAddr(), config.SectionName, err)
+				localConn.Close()
+				return
+			}
+
+			// Bound the dial so a stalled channel open doesn't hold the client
+			start := time.Now()
+			target := config.remoteTarget()
+			dialCtx, cancel := context.WithTimeout(ctx, targetDialTimeout(config))
+			dial := func() (net.Conn, error) {
+				return dialChannel(dialCtx, conn, config, target, commonConfig)
+			}
+			remoteConn, err := dial()
+			if err == nil && config.CompressRelay {
+				remoteConn, err = negotiateCompression(config, remoteConn, dial)
+			}
+			cancel()
 			if err != nil {
-				log.Printf("Failed to connect to remote address: %v", err)
+				connLogf(localConn, "Failed to connect to remote address: %v", err)
 				localConn.Close()
 				return
 			}
 
-			go copyConn(localConn, remoteConn, commonConfig)
-			go copyConn(remoteConn, localConn, commonConfig)
-		}()
+			sent, received := relay(localConn, remoteConn, config, commonConfig)
+			emitTransfer(config, localConn.RemoteAddr(), target, start, sent, received)
+		}))
 	}
 }
 
-func handleForwardingConnection(incomingConn net.Conn, targetIP, targetPort string, commonConfig *CommonConfig) {
-	targetConn, err := net.Dial("tcp", fmt.Sprintf("%s:%s", targetIP, targetPort))
+func handleForwardingConnection(incomingConn net.Conn, config *ForwardConfig, commonConfig *CommonConfig) {
+	start := time.Now()
+	target := net.JoinHostPort(config.LocalIP, config.LocalPort)
+	targetConn, err := dscpDialer(config).Dial("tcp", target)
 	if err != nil {
-		log.Printf("Failed to connect to target address: %v", err)
+		connLogf(incomingConn, "Failed to connect to target address: %v", err)
 		incomingConn.Close()
 		return
 	}
+	warnSlowDial(target+" for "+config.SectionName, start, commonConfig.SlowDialThreshold)
 
-	go copyConn(targetConn, incomingConn, commonConfig)
-	go copyConn(incomingConn, targetConn, commonConfig)
+	sent, received := relay(incomingConn, targetConn, config, commonConfig)
+	emitTransfer(config, incomingConn.RemoteAddr(), target, start, sent, received)
 }
 
-func handleSocks5Proxy(conn *ssh.Client, config *ForwardConfig, commonConfig *CommonConfig) error {
-	listener, err := net.Listen("tcp", fmt.Sprintf("%s:%s", config.LocalIP, config.LocalPort))
+func handleSocks5Proxy(ctx context.Context, conn *ssh.Client, config *ForwardConfig, commonConfig *CommonConfig) error {
+	listener, err := listenLocal(config)
 	if err != nil {
 		return fmt.Errorf("failed to listen on local address: %v", err)
 	}
+	listener = wrapListenerTLS(wrapListenerDSCP(wrapListenerRate(wrapListenerSource(listener, config), config), config), config)
 	defer listener.Close()
 
-	log.Printf("SOCKS5 proxy listening on %s:%s", config.LocalIP, config.LocalPort)
+	// Remember the bound address, which has the chosen port when 0 was configured
+	config.setListenAddr(listener.Addr().String())
+	defer config.setListenAddr("")
+
+	// Close the listener on shutdown or reload so Accept returns
+	stop := context.AfterFunc(ctx, func() { listener.Close() })
+	defer stop()
+
+	if config.tlsConfig != nil {
+		log.Printf("SOCKS5 proxy listening on %s (TLS)", listener.Addr())
+	} else {
+		log.Printf("SOCKS5 proxy listening on %s", listener.Addr())
+	}
+	if config.HealthCheck > 0 {
+		go runHealthCheck(ctx, config, commonConfig)
+	}
 
+	workers := startWorkerPool(ctx, config)
 	for {
 		clientConn, err := listener.Accept()
 		if err != nil {
+			// Listener closed by shutdown, reload or stop
+			if ctx.Err() != nil {
+				return nil
+			}
 			return fmt.Errorf("failed to accept connection: %v", err)
 		}
 
-		go handleSocks5Connection(clientConn, conn, config, commonConfig)
+		// [common] as of this connection; a reload publishes a new one
+		commonConfig := currentCommon()
+		workers.run(ctx, clientConn, traceConnection(commonConfig, config, clientConn, func() {
+			handleSocks5Connection(ctx, clientConn, conn, config, commonConfig)
+		}))
 	}
 }
 
-func handleSocks5Connection(clientConn net.Conn, sshConn *ssh.Client, config *ForwardConfig, commonConfig *CommonConfig) {
+func handleSocks5Connection(ctx context.Context, clientConn net.Conn, sshConn *ssh.Client, config *ForwardConfig, commonConfig *CommonConfig) {
 	defer clientConn.Close()
 
 	// Create a SOCKS5 server that uses the SSH connection for dialing
 	socks5Server := &socks5Server{
+		ctx:     ctx,
 		sshConn: sshConn,
 		config:  config,
 	}
@@ -268,516 +581,164 @@ func handleSocks5Connection(clientConn net.Conn, sshConn *ssh.Client, config *Fo
 	// Handle the SOCKS5 protocol
 	err := socks5Server.handleConnection(clientConn, commonConfig)
 	if err != nil {
-		log.Printf("SOCKS5 connection error: %v", err)
+		connLogf(clientConn, "SOCKS5 connection error: %v", err)
 	}
 }
 
-type socks5Server struct {
-	sshConn *ssh.Client
-	config  *ForwardConfig
-}
-
-func (s *socks5Server) handleConnection(clientConn net.Conn, commonConfig *CommonConfig) error {
-	// Read SOCKS5 version and number of authentication methods
-	buf := make([]byte, 256)
-	n, err := clientConn.Read(buf)
-	if err != nil {
-		return fmt.Errorf("failed to read SOCKS5 greeting: %v", err)
-	}
-
-	if n < 2 || buf[0] != 0x05 {
-		return fmt.Errorf("invalid SOCKS5 version")
-	}
-
-	// Check if authentication is required
-	requireAuth := s.config.Socks5User != "" && s.config.Socks5Pass != ""
-
-	// Parse supported authentication methods
-	numMethods := int(buf[1])
-	if n < 2+numMethods {
-		return fmt.Errorf("invalid authentication methods")
-	}
-
-	supportedMethods := buf[2 : 2+numMethods]
-	var selectedMethod byte = 0xFF // No acceptable methods
-
-	if requireAuth {
-		// Check if client supports username/password authentication (method 0x02)
-		for _, method := range supportedMethods {
-			if method == 0x02 {
-				selectedMethod = 0x02
-				break
-			}
-		}
-	} else {
-		// Check if client supports no authentication (method 0x00)
-		for _, method := range supportedMethods {
-			if method == 0x00 {
-				selectedMethod = 0x00
-				break
-			}
-		}
-	}
-
-	// Send authentication method selection response
-	_, err = clientConn.Write([]byte{0x05, selectedMethod})
-	if err != nil {
-		return fmt.Errorf("failed to send auth method response: %v", err)
-	}
-
-	if selectedMethod == 0xFF {
-		return fmt.Errorf("no acceptable authentication methods")
-	}
-
-	// Handle authentication if required
-	if selectedMethod == 0x02 {
-		err = s.handleUsernamePasswordAuth(clientConn, commonConfig)
-		if err != nil {
-			return fmt.Errorf("authentication failed: %v", err)
-		}
-	}
-
-	// Read connection request
-	n, err = clientConn.Read(buf)
-	if err != nil {
-		return fmt.Errorf("failed to read connection request: %v", err)
-	}
-
-	if n < 4 || buf[0] != 0x05 || buf[1] != 0x01 {
-		return fmt.Errorf("invalid SOCKS5 connection request")
-	}
-
-	// Parse target address
-	var targetAddr string
-	var targetPort uint16
-
-	switch buf[3] { // Address type
-	case 0x01: // IPv4
-		if n < 10 {
-			return fmt.Errorf("invalid IPv4 address length")
-		}
-		targetAddr = fmt.Sprintf("%d.%d.%d.%d", buf[4], buf[5], buf[6], buf[7])
-		targetPort = uint16(buf[8])<<8 | uint16(buf[9])
-	case 0x03: // Domain name
-		if n < 5 {
-			return fmt.Errorf("invalid domain name length")
-		}
-		domainLen := int(buf[4])
-		if n < 5+domainLen+2 {
-			return fmt.Errorf("incomplete domain name")
-		}
-		targetAddr = string(buf[5 : 5+domainLen])
-		targetPort = uint16(buf[5+domainLen])<<8 | uint16(buf[5+domainLen+1])
-	case 0x04: // IPv6
-		if n < 22 {
-			return fmt.Errorf("invalid IPv6 address length")
-		}
-		// IPv6 address parsing
-		ipv6 := net.IP(buf[4:20])
-		targetAddr = ipv6.String()
-		targetPort = uint16(buf[20])<<8 | uint16(buf[21])
-	default:
-		return fmt.Errorf("unsupported address type: %d", buf[3])
-	}
-
-	target := fmt.Sprintf("%s:%d", targetAddr, targetPort)
-
-	// Connect to target through SSH tunnel
-	remoteConn, err := s.sshConn.Dial("tcp", target)
-	if err != nil {
-		// Send connection failed response
-		response := []byte{0x05, 0x05, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}
-		clientConn.Write(response)
-		return fmt.Errorf("failed to connect to target %s: %v", target, err)
-	}
-	defer remoteConn.Close()
-
-	// Send success response
-	response := []byte{0x05, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}
-	_, err = clientConn.Write(response)
-	if err != nil {
-		return fmt.Errorf("failed to send success response: %v", err)
-	}
-
-	if commonConfig.Debug {
-		log.Printf("SOCKS5 connection established to %s", target)
-	}
-
-	// Start bidirectional data transfer and wait for completion
-	done := make(chan bool, 2)
-
-	go func() {
-		copyConn(clientConn, remoteConn, commonConfig)
-		done <- true
-	}()
-
-	go func() {
-		copyConn(remoteConn, clientConn, commonConfig)
-		done <- true
-	}()
-
-	// Wait for either direction to complete
-	<-done
-
-	return nil
-}
-
-func (s *socks5Server) handleUsernamePasswordAuth(clientConn net.Conn, commonConfig *CommonConfig) error {
-	buf := make([]byte, 256)
-	n, err := clientConn.Read(buf)
-	if err != nil {
-		return fmt.Errorf("failed to read auth request: %v", err)
-	}
-
-	if n < 2 || buf[0] != 0x01 {
-		return fmt.Errorf("invalid auth version")
-	}
-
-	// Parse username
-	userLen := int(buf[1])
-	if n < 2+userLen+1 {
-		return fmt.Errorf("invalid username length")
-	}
-	username := string(buf[2 : 2+userLen])
-
-	// Parse password
-	passLen := int(buf[2+userLen])
-	if n < 2+userLen+1+passLen {
-		return fmt.Errorf("invalid password length")
-	}
-	password := string(buf[2+userLen+1 : 2+userLen+1+passLen])
-
-	// Verify credentials
-	if username == s.config.Socks5User && password == s.config.Socks5Pass {
-		// Authentication successful
-		_, err = clientConn.Write([]byte{0x01, 0x00})
-		if err != nil {
-			return fmt.Errorf("failed to send auth success: %v", err)
-		}
-		if commonConfig.Debug {
-			log.Printf("SOCKS5 authentication successful for user: %s", username)
-		}
-		return nil
-	} else {
-		// Authentication failed
-		_, err = clientConn.Write([]byte{0x01, 0x01})
-		if err != nil {
-			return fmt.Errorf("failed to send auth failure: %v", err)
-		}
-		return fmt.Errorf("invalid credentials for user: %s", username)
-	}
-}
-
-func handleReverseSocks5Proxy(conn *ssh.Client, config *ForwardConfig, commonConfig *CommonConfig) error {
+func handleReverseSocks5Proxy(ctx context.Context, conn *ssh.Client, config *ForwardConfig, commonConfig *CommonConfig) error {
 	// Listen on remote server
-	listener, err := conn.Listen("tcp", fmt.Sprintf("%s:%s", config.RemoteIP, config.RemotePort))
+	listener, err := listenRemote(ctx, conn, config.RemoteIP, config.RemotePort)
 	if err != nil {
 		return fmt.Errorf("failed to listen on remote server: %v", err)
 	}
 	defer listener.Close()
 
-	log.Printf("Reverse SOCKS5 proxy listening on remote %s:%s", config.RemoteIP, config.RemotePort)
+	// Remember the bound address, which has the chosen port when 0 was configured
+	config.setListenAddr(listener.Addr().String())
+	defer config.setListenAddr("")
 
+	// Close the listener on shutdown or reload so Accept returns
+	stop := context.AfterFunc(ctx, func() { listener.Close() })
+	defer stop()
+
+	log.Printf("Reverse SOCKS5 proxy listening on remote %s", listener.Addr())
+
+	workers := startWorkerPool(ctx, config)
 	for {
 		remoteConn, err := listener.Accept()
 		if err != nil {
+			// Listener closed by shutdown, reload or stop
+			if ctx.Err() != nil {
+				return nil
+			}
 			return fmt.Errorf("failed to accept connection: %v", err)
 		}
 
-		go handleReverseSocks5Connection(remoteConn, config, commonConfig)
+		// [common] as of this connection; a reload publishes a new one
+		commonConfig := currentCommon()
+		channel := connManager.trackChannel(conn, remoteConn)
+		workers.run(ctx, channel, traceConnection(commonConfig, config, channel, func() {
+			handleReverseSocks5Connection(ctx, channel, conn, config, commonConfig)
+		}))
 	}
 }
 
-func handleReverseSocks5Connection(remoteConn net.Conn, config *ForwardConfig, commonConfig *CommonConfig) {
+func handleReverseSocks5Connection(ctx context.Context, remoteConn net.Conn, sshConn *ssh.Client, config *ForwardConfig, commonConfig *CommonConfig) {
 	defer remoteConn.Close()
 
 	// Create a reverse SOCKS5 server that dials to local network
-	reverseSocks5Server := &reverseSocks5Server{config: config}
+	reverseSocks5Server := &reverseSocks5Server{
+		ctx:     ctx,
+		sshConn: sshConn,
+		config:  config,
+	}
 
 	// Handle the SOCKS5 protocol
 	err := reverseSocks5Server.handleConnection(remoteConn, commonConfig)
 	if err != nil {
-		log.Printf("Reverse SOCKS5 connection error: %v", err)
-	}
-}
-
-type reverseSocks5Server struct {
-	config *ForwardConfig
-}
-
-func (s *reverseSocks5Server) handleConnection(clientConn net.Conn, commonConfig *CommonConfig) error {
-	// Read SOCKS5 version and number of authentication methods
-	buf := make([]byte, 256)
-	n, err := clientConn.Read(buf)
-	if err != nil {
-		return fmt.Errorf("failed to read SOCKS5 greeting: %v", err)
-	}
-
-	if n < 2 || buf[0] != 0x05 {
-		return fmt.Errorf("invalid SOCKS5 version")
-	}
-
-	// Check if authentication is required
-	requireAuth := s.config.Socks5User != "" && s.config.Socks5Pass != ""
-
-	// Parse supported authentication methods
-	numMethods := int(buf[1])
-	if n < 2+numMethods {
-		return fmt.Errorf("invalid authentication methods")
-	}
-
-	supportedMethods := buf[2 : 2+numMethods]
-	var selectedMethod byte = 0xFF // No acceptable methods
-
-	if requireAuth {
-		// Check if client supports username/password authentication (method 0x02)
-		for _, method := range supportedMethods {
-			if method == 0x02 {
-				selectedMethod = 0x02
-				break
-			}
-		}
-	} else {
-		// Check if client supports no authentication (method 0x00)
-		for _, method := range supportedMethods {
-			if method == 0x00 {
-				selectedMethod = 0x00
-				break
-			}
-		}
-	}
-
-	// Send authentication method selection response
-	_, err = clientConn.Write([]byte{0x05, selectedMethod})
-	if err != nil {
-		return fmt.Errorf("failed to send auth method response: %v", err)
-	}
-
-	if selectedMethod == 0xFF {
-		return fmt.Errorf("no acceptable authentication methods")
-	}
-
-	// Handle authentication if required
-	if selectedMethod == 0x02 {
-		err = s.handleUsernamePasswordAuth(clientConn, commonConfig)
-		if err != nil {
-			return fmt.Errorf("authentication failed: %v", err)
-		}
-	}
-
-	// Read connection request
-	n, err = clientConn.Read(buf)
-	if err != nil {
-		return fmt.Errorf("failed to read connection request: %v", err)
-	}
-
-	if n < 4 || buf[0] != 0x05 || buf[1] != 0x01 {
-		return fmt.Errorf("invalid SOCKS5 connection request")
-	}
-
-	// Parse target address
-	var targetAddr string
-	var targetPort uint16
-
-	switch buf[3] { // Address type
-	case 0x01: // IPv4
-		if n < 10 {
-			return fmt.Errorf("invalid IPv4 address length")
-		}
-		targetAddr = fmt.Sprintf("%d.%d.%d.%d", buf[4], buf[5], buf[6], buf[7])
-		targetPort = uint16(buf[8])<<8 | uint16(buf[9])
-	case 0x03: // Domain name
-		if n < 5 {
-			return fmt.Errorf("invalid domain name length")
-		}
-		domainLen := int(buf[4])
-		if n < 5+domainLen+2 {
-			return fmt.Errorf("incomplete domain name")
-		}
-		targetAddr = string(buf[5 : 5+domainLen])
-		targetPort = uint16(buf[5+domainLen])<<8 | uint16(buf[5+domainLen+1])
-	case 0x04: // IPv6
-		if n < 22 {
-			return fmt.Errorf("invalid IPv6 address length")
-		}
-		// IPv6 address parsing
-		ipv6 := net.IP(buf[4:20])
-		targetAddr = ipv6.String()
-		targetPort = uint16(buf[20])<<8 | uint16(buf[21])
-	default:
-		return fmt.Errorf("unsupported address type: %d", buf[3])
-	}
-
-	target := fmt.Sprintf("%s:%d", targetAddr, targetPort)
-
-	// Add DNS resolution debugging for domain names
-	if buf[3] == 0x03 { // Domain name
-		_, err := net.LookupIP(targetAddr)
-		if err != nil {
-			log.Printf("Reverse SOCKS5 DNS resolution failed for %s: %v", targetAddr, err)
-		}
-	}
-
-	// For reverse SOCKS5, we need to connect through the local machine's internet connection
-	// This allows the remote server to access the internet through our local connection
-	dialer := &net.Dialer{
-		Timeout: 30 * time.Second,
-	}
-	localConn, err := dialer.Dial("tcp", target)
-	if err != nil {
-		if commonConfig.Debug {
-			log.Printf("Reverse SOCKS5 connection failed to %s: %v", target, err)
-		}
-		// Send connection failed response
-		response := []byte{0x05, 0x05, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}
-		clientConn.Write(response)
-		return fmt.Errorf("failed to connect to target %s through local connection: %v", target, err)
-	}
-	defer localConn.Close()
-
-	// Send success response
-	response := []byte{0x05, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}
-	_, err = clientConn.Write(response)
-	if err != nil {
-		return fmt.Errorf("failed to send success response: %v", err)
-	}
-
-	if commonConfig.Debug {
-		log.Printf("Reverse SOCKS5 connection established: %s", target)
-	}
-
-	// Start bidirectional data transfer and wait for completion
-	done := make(chan bool, 2)
-
-	go func() {
-		copyConn(clientConn, localConn, commonConfig)
-		done <- true
-	}()
-
-	go func() {
-		copyConn(localConn, clientConn, commonConfig)
-		done <- true
-	}()
-
-	// Wait for either direction to complete
-	<-done
-
-	return nil
-}
-
-func (s *reverseSocks5Server) handleUsernamePasswordAuth(clientConn net.Conn, commonConfig *CommonConfig) error {
-	buf := make([]byte, 256)
-	n, err := clientConn.Read(buf)
-	if err != nil {
-		return fmt.Errorf("failed to read auth request: %v", err)
-	}
-
-	if n < 2 || buf[0] != 0x01 {
-		return fmt.Errorf("invalid auth version")
-	}
-
-	// Parse username
-	userLen := int(buf[1])
-	if n < 2+userLen+1 {
-		return fmt.Errorf("invalid username length")
-	}
-	username := string(buf[2 : 2+userLen])
-
-	// Parse password
-	passLen := int(buf[2+userLen])
-	if n < 2+userLen+1+passLen {
-		return fmt.Errorf("invalid password length")
-	}
-	password := string(buf[2+userLen+1 : 2+userLen+1+passLen])
-
-	// Verify credentials
-	if username == s.config.Socks5User && password == s.config.Socks5Pass {
-		// Authentication successful
-		_, err = clientConn.Write([]byte{0x01, 0x00})
-		if err != nil {
-			return fmt.Errorf("failed to send auth success: %v", err)
-		}
-		if commonConfig.Debug {
-			log.Printf("Reverse SOCKS5 authentication successful for user: %s", username)
-		}
-		return nil
-	} else {
-		// Authentication failed
-		_, err = clientConn.Write([]byte{0x01, 0x01})
-		if err != nil {
-			return fmt.Errorf("failed to send auth failure: %v", err)
-		}
-		return fmt.Errorf("invalid credentials for user: %s", username)
-	}
-}
-
-func copyConn(dst io.WriteCloser, src io.ReadCloser, commonConfig *CommonConfig) {
-	defer dst.Close()
-	defer src.Close()
-
-	_, err := io.Copy(dst, src)
-	if err != nil && err != io.EOF && commonConfig.Debug {
-		log.Printf("Data transfer error: %v", err)
+		connLogf(remoteConn, "Reverse SOCKS5 connection error: %v", err)
 	}
 }
 
 // Connection manager methods
 func (cm *ConnectionManager) GetConnection(serverName string) (*ssh.Client, error) {
+	// Servers with a connection pool hand out their least busy connection
+	key := cm.nextPoolKey(serverName)
+
 	cm.mutex.RLock()
-	if conn, exists := cm.connections[serverName]; exists && conn != nil {
+	if conn, exists := cm.connections[key]; exists && conn != nil {
 		cm.mutex.RUnlock()
 		return conn, nil
 	}
 	cm.mutex.RUnlock()
 
-	// Connection doesn't exist, create it
-	return cm.createConnection(serverName)
+	// Connection doesn't exist, create it together with any other callers
+	// waiting for the same one
+	return cm.coalesceDial(key, func() (*ssh.Client, error) {
+		return cm.createConnection(key)
+	})
 }
 
 func (cm *ConnectionManager) createConnection(serverName string) (*ssh.Client, error) {
-	cm.mutex.Lock()
-	defer cm.mutex.Unlock()
-
-	// Double-check after acquiring write lock
+	// Double-check, another caller may have connected in the meantime
+	cm.mutex.RLock()
 	if conn, exists := cm.connections[serverName]; exists && conn != nil {
+		cm.mutex.RUnlock()
 		return conn, nil
 	}
+	cm.mutex.RUnlock()
 
-	// Get server config
-	serverConfig, ok := servers[serverName]
-	if !ok {
+	// Get server config, for pooled connections that of the pool's server
+	serverConfig := lookupServer(poolServerName(serverName))
+	if serverConfig == nil {
 		return nil, fmt.Errorf("server configuration not found for %s", serverName)
 	}
 
+	auth, err := newSSHAuth(serverConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set up authentication for %s: %v", serverName, err)
+	}
+	defer auth.Close()
+
 	// Create SSH config
 	sshConfig := &ssh.ClientConfig{
-		User: serverConfig.User,
-		Auth: []ssh.AuthMethod{
-			ssh.Password(serverConfig.Password),
-		},
+		User:            serverConfig.User,
+		Auth:            auth.Methods,
 		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
 		Timeout:         10 * time.Second,
+		Config:          algorithmConfig(serverConfig),
+		BannerCallback:  bannerCallback(serverName, serverConfig),
 	}
 
-	// Establish connection
-	conn, err := ssh.Dial("tcp", fmt.Sprintf("%s:%s", serverConfig.Server, serverConfig.Port), sshConfig)
+	// Establish connection without holding the lock, so other servers and
+	// status queries are not held up by a slow dial
+	cm.mutex.Lock()
+	cm.beginDial(serverName)
+	cm.mutex.Unlock()
+	dialStart := time.Now()
+	conn, err := dialServer(serverConfig, sshConfig)
 	if err != nil {
+		cm.mutex.Lock()
+		cm.setState(serverName, stateFailed)
+		cm.mutex.Unlock()
 		return nil, fmt.Errorf("failed to dial %s: %v", serverName, err)
 	}
+	warnSlowDial("server "+serverName, dialStart, serverConfig.slowDial)
 
 	// Store connection
+	cm.mutex.Lock()
 	cm.connections[serverName] = conn
+	cm.trackConnection(conn)
+	cm.recordConnect(serverName, conn)
+	cm.signalConnected()
+	cm.mutex.Unlock()
+	if serverConfig.warnOld {
+		warnOldServer(serverName, string(conn.ServerVersion()))
+	}
+
+	// Start connection monitor, unless disabled for short-lived use where
+	// failed dials are enough to notice a dead connection
+	if serverConfig.Monitor {
+		go cm.monitorConnection(serverName, conn)
+	}
 
-	// Start connection monitor
-	go cm.monitorConnection(serverName, conn)
+	log.Printf("Created shared SSH connection for server: %s (authenticated with %s)", serverName, auth.Used())
+	emitEvent(Event{Type: "connect", Server: serverName})
 
-	log.Printf("Created shared SSH connection for server: %s", serverName)
+	// Checking the account once is enough: reconnects use the same credentials
+	if serverConfig.VerifyUser {
+		serverConfig.verifyOnce.Do(func() {
+			go verifyRemoteUser(serverName, serverConfig, conn)
+		})
+	}
 	return conn, nil
 }
 
 func (cm *ConnectionManager) monitorConnection(serverName string, conn *ssh.Client) {
 	ticker := time.NewTicker(30 * time.Second)
 	defer ticker.Stop()
+	connectedAt := time.Now()
+	missed := 0 // consecutive unanswered keepalives
 
 	for {
 		select {
@@ -787,12 +748,23 @@ func (cm *ConnectionManager) monitorConnection(serverName string, conn *ssh.Clie
 				log.Printf("SSH connection lost for server: %s", serverName)
 				goto cleanup
 			}
-			// Send a keep-alive ping
-			_, _, err := conn.SendRequest("keepalive@openssh.com", true, nil)
-			if err != nil {
+			// Send a keep-alive ping, tolerating a few unanswered ones
+			if err := sendKeepalive(serverName, conn); err != nil {
+				if err == errKeepaliveTimeout {
+					missed++
+					if cm.tolerateMissedKeepalive(serverName, missed) {
+						continue
+					}
+				}
 				log.Printf("SSH connection failed for server: %s: %v", serverName, err)
 				goto cleanup
 			}
+			missed = 0
+			cm.recordKeepalive(serverName)
+			cm.logChannels(serverName, conn)
+			if cm.shouldRotate(serverName, conn, connectedAt) {
+				goto cleanup
+			}
 		case <-cm.ctx.Done():
 			log.Printf("Context cancelled, closing SSH connection for server: %s", serverName)
 			goto cleanup
@@ -800,10 +772,16 @@ func (cm *ConnectionManager) monitorConnection(serverName string, conn *ssh.Clie
 	}
 
 cleanup:
-	// Remove connection from map
+	// Close the connection so forwards using it restart, and remove it from
+	// the map unless it has already been replaced
 	cm.mutex.Lock()
-	delete(cm.connections, serverName)
+	cm.markLost(conn)
+	if cm.connections[serverName] == conn {
+		delete(cm.connections, serverName)
+		cm.setState(serverName, stateReconnecting)
+	}
 	cm.mutex.Unlock()
+	emitEvent(Event{Type: "disconnect", Server: serverName})
 }
 
 func (cm *ConnectionManager) CloseAll() {
@@ -812,20 +790,10 @@ func (cm *ConnectionManager) CloseAll() {
 
 	for serverName, conn := range cm.connections {
 		if conn != nil {
-			conn.Close()
+			cm.markLost(conn)
 			log.Printf("Closed SSH connection for server: %s", serverName)
+			emitEvent(Event{Type: "disconnect", Server: serverName})
 		}
 	}
 	cm.connections = make(map[string]*ssh.Client)
 }
-
-func (cm *ConnectionManager) RemoveConnection(serverName string) {
-	cm.mutex.Lock()
-	defer cm.mutex.Unlock()
-
-	if conn, exists := cm.connections[serverName]; exists && conn != nil {
-		conn.Close()
-		log.Printf("Removed failed SSH connection for server: %s", serverName)
-	}
-	delete(cm.connections, serverName)
-}
@@ -5,67 +5,44 @@ package main
 
 import (
 	"context"
+	_ "embed"
+	"errors"
+	"flag"
 	"fmt"
-	"io"
 	"log"
 	"net"
 	"os"
+	"strings"
 	"sync"
 	"time"
+	// Windows has no zoneinfo database for the timezone option
+	_ "time/tzdata"
 
 	"github.com/getlantern/systray"
 	"golang.org/x/crypto/ssh"
-	"gopkg.in/ini.v1"
 )
 
-// Struct definitions
-type ServerConfig struct {
-	Server   string
-	User     string
-	Password string
-	Port     string
-}
-
-type CommonConfig struct {
-	Debug bool
-}
-
-type ForwardConfig struct {
-	SectionName string // Original section name from config.ini
-	ServerName  string
-	RemoteIP    string
-	RemotePort  string
-	LocalIP     string
-	LocalPort   string
-	Direction   string
-	SSHConfig   *ServerConfig
-	// SOCKS5 authentication
-	Socks5User string
-	Socks5Pass string
-}
+// Default tray icon used when no icon.ico is present next to the executable
+//
+//go:embed icon.ico
+var defaultIcon []byte
 
 // Connection manager for shared SSH connections
 type ConnectionManager struct {
 	connections map[string]*ssh.Client
+	stats       map[string]*connectionStats
+	lost        map[*ssh.Client]chan struct{}
+	channels    map[*ssh.Client]*int64
+	connected   chan struct{} // closed and replaced on every new connection
 	mutex       sync.RWMutex
+	dials       map[string]*pendingDial // in-flight dials, by connection key
+	dialMutex   sync.Mutex
 	ctx         context.Context
 	cancel      context.CancelFunc
 }
 
-// SOCKS5 server types
-type socks5Server struct {
-	sshConn *ssh.Client
-	config  *ForwardConfig
-}
-
-type reverseSocks5Server struct {
-	config *ForwardConfig
-}
-
 var (
-	cfg            *ini.File
 	commonConfig   *CommonConfig
-	servers        map[string]*ServerConfig
 	forwardConfigs []*ForwardConfig
 	connManager    *ConnectionManager
 	ctx            context.Context
@@ -80,64 +57,36 @@ func main() {
 	// Initialize connection manager
 	connManager = &ConnectionManager{
 		connections: make(map[string]*ssh.Client),
+		stats:       make(map[string]*connectionStats),
+		lost:        make(map[*ssh.Client]chan struct{}),
+		channels:    make(map[*ssh.Client]*int64),
+		connected:   make(chan struct{}),
+		dials:       make(map[string]*pendingDial),
 		ctx:         ctx,
 		cancel:      cancel,
 	}
 
 	// Load configuration
-	var err error
-	cfg, err = ini.Load("config.ini")
+	configFlag := flag.String("config", "", "path to the config file (default: SPF_CONFIG or the first existing config.ini in the search path)")
+	flag.Parse()
+
+	configPath := findConfigFile(*configFlag)
+	config, err := loadConfig(configPath)
 	if err != nil {
-		log.Fatalf("Failed to load config file: %v", err)
+		log.Fatalf("Failed to load config file %s: %v", configPath, err)
 	}
-
-	// Parse common configuration
-	commonConfig = &CommonConfig{}
-	if cfg.HasSection("common") {
-		commonSection := cfg.Section("common")
-		commonConfig.Debug = commonSection.Key("debug").MustBool(false)
+	log.Printf("Loaded configuration from %s", configPath)
+	if errs := validateConfig(config); len(errs) > 0 {
+		log.Fatalf("Invalid configuration: %v", errors.Join(errs...))
 	}
+	commonConfig = config.Common
+	liveCommon.Store(config.Common)
+	publishServers(config.Servers)
+	forwardConfigs = config.Forwards
 
-	// Parse server configurations
-	servers = make(map[string]*ServerConfig)
-	for _, section := range cfg.Sections() {
-		if section.Name() == "DEFAULT" || section.Name() == "common" {
-			continue
-		}
-
-		if section.HasKey("user") && section.HasKey("password") {
-			port := section.Key("port").String()
-			if port == "" {
-				port = "22" // Default SSH port
-			}
-			servers[section.Name()] = &ServerConfig{
-				Server:   section.Key("server").String(),
-				User:     section.Key("user").String(),
-				Password: section.Key("password").String(),
-				Port:     port,
-			}
-		} else if section.HasKey("server") && section.HasKey("direction") {
-			forwardConfig := &ForwardConfig{
-				SectionName: section.Name(),
-				ServerName:  section.Key("server").String(),
-				RemoteIP:    section.Key("remoteIP").String(),
-				RemotePort:  section.Key("remotePort").String(),
-				LocalIP:     section.Key("localIP").String(),
-				LocalPort:   section.Key("localPort").String(),
-				Direction:   section.Key("direction").String(),
-				Socks5User:  section.Key("socks5User").String(),
-				Socks5Pass:  section.Key("socks5Pass").String(),
-			}
-			forwardConfigs = append(forwardConfigs, forwardConfig)
-		}
-	}
-
-	// Link forward configs to server configs
-	for _, fc := range forwardConfigs {
-		if sshConfig, ok := servers[fc.ServerName]; ok {
-			fc.SSHConfig = sshConfig
-		} else {
-			log.Printf("Warning: No server configuration found for %s", fc.ServerName)
+	if config.Common.EventSocket != "" {
+		if err := startEventSocket(config.Common.EventSocket); err != nil {
+			log.Fatalf("Failed to start event socket: %v", err)
 		}
 	}
 
@@ -146,12 +95,8 @@ func main() {
 }
 
 func onReady() {
-	// Set icon
-	iconPath := "icon.ico"
-	if _, err := os.Stat(iconPath); os.IsNotExist(err) {
-		log.Fatalf("Icon file not found: %s. Please provide an icon.ico file.", iconPath)
-	}
-	systray.SetIcon(getIcon(iconPath))
+	// Set icon, falling back to the embedded default
+	systray.SetIcon(getIcon("icon.ico"))
 	systray.SetTitle("SSH Port Forwarder")
 	systray.SetTooltip("SSH Port Forwarder - Running")
 
@@ -162,7 +107,7 @@ func onReady() {
 	// Group forward configurations by server
 	serverGroups := make(map[string][]*ForwardConfig)
 	for _, fc := range forwardConfigs {
-		if fc.SSHConfig != nil {
+		if fc.runsInBackground() {
 			serverGroups[fc.ServerName] = append(serverGroups[fc.ServerName], fc)
 		}
 	}
@@ -172,6 +117,7 @@ func onReady() {
 		// Add server section header with connection status
 		serverMenuItem := systray.AddMenuItem(fmt.Sprintf("%s", serverName), fmt.Sprintf("Server: %s", serverName))
 		serverMenuItem.Disable() // Make it non-clickable
+		go updateServerTooltip(serverMenuItem, splitList(serverName))
 
 		// Add port configurations under this server
 		for _, fc := range configs {
@@ -183,11 +129,14 @@ func onReady() {
 				name = fmt.Sprintf("  %s %s:%s r → l %s:%s", fc.SectionName, fc.RemoteIP, fc.RemotePort, fc.LocalIP, fc.LocalPort)
 				tooltip = fmt.Sprintf("Remote port forward: %s:%s → %s:%s", fc.RemoteIP, fc.RemotePort, fc.LocalIP, fc.LocalPort)
 			case "local":
-				name = fmt.Sprintf("  %s %s:%s l → r %s:%s", fc.SectionName, fc.LocalIP, fc.LocalPort, fc.RemoteIP, fc.RemotePort)
-				tooltip = fmt.Sprintf("Local port forward: %s:%s ← %s:%s", fc.LocalIP, fc.LocalPort, fc.RemoteIP, fc.RemotePort)
+				name = fmt.Sprintf("  %s %s:%s l → r %s", fc.SectionName, fc.LocalIP, fc.LocalPort, fc.remoteTarget())
+				tooltip = fmt.Sprintf("Local port forward: %s:%s ← %s", fc.LocalIP, fc.LocalPort, fc.remoteTarget())
 			case "socks5":
 				name = fmt.Sprintf("  %s %s:%s l ← SOCKS5", fc.SectionName, fc.LocalIP, fc.LocalPort)
 				tooltip = fmt.Sprintf("SOCKS5 proxy: %s:%s", fc.LocalIP, fc.LocalPort)
+			case "sni-route":
+				name = fmt.Sprintf("  %s %s:%s l → SNI", fc.SectionName, fc.LocalIP, fc.LocalPort)
+				tooltip = fmt.Sprintf("SNI routing: %s:%s (%d routes)", fc.LocalIP, fc.LocalPort, len(fc.SNIRoutes))
 			case "reverse-socks5":
 				name = fmt.Sprintf("  %s %s:%s r → SOCKS5", fc.SectionName, fc.RemoteIP, fc.RemotePort)
 				tooltip = fmt.Sprintf("Reverse SOCKS5 proxy: %s:%s", fc.RemoteIP, fc.RemotePort)
@@ -216,8 +165,43 @@ func onReady() {
 
 	// Start all forward connections
 	for _, fc := range forwardConfigs {
-		if fc.SSHConfig != nil {
-			go handleConnection(fc, commonConfig)
+		if fc.runsInBackground() {
+			go runScheduled(ctx, fc, commonConfig, handleConnection)
+		}
+	}
+}
+
+// updateServerTooltip keeps a server menu item's tooltip showing the uptime
+// and reconnect count of its servers.
+func updateServerTooltip(menuItem *systray.MenuItem, serverNames []string) {
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		var lines []string
+		for _, name := range serverNames {
+			stats := connManager.Stats(name)
+			connManager.mutex.RLock()
+			conn := connManager.connections[name]
+			connManager.mutex.RUnlock()
+
+			label := name
+			if stats.Version != "" {
+				label = fmt.Sprintf("%s (%s)", name, stats.Version)
+			}
+			if conn != nil {
+				uptime := time.Since(stats.ConnectedAt).Round(time.Second)
+				lines = append(lines, fmt.Sprintf("Server: %s, %s, up %s, %d reconnects", label, stats.State, uptime, stats.Reconnects))
+			} else {
+				lines = append(lines, fmt.Sprintf("Server: %s, %s, %d reconnects", label, stats.State, stats.Reconnects))
+			}
+		}
+		menuItem.SetTooltip(strings.Join(lines, "\n"))
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
 		}
 	}
 }
@@ -247,13 +231,15 @@ func handleMenuItemClick(menuItem *systray.MenuItem, config *ForwardConfig) {
 			log.Printf("Remote Port Forward: %s:%s → %s:%s",
 				config.RemoteIP, config.RemotePort, config.LocalIP, config.LocalPort)
 		case "local":
-			log.Printf("Local Port Forward: %s:%s ← %s:%s",
-				config.LocalIP, config.LocalPort, config.RemoteIP, config.RemotePort)
+			log.Printf("Local Port Forward: %s:%s ← %s",
+				config.LocalIP, config.LocalPort, config.remoteTarget())
 		case "socks5":
 			log.Printf("SOCKS5 Proxy: %s:%s", config.LocalIP, config.LocalPort)
 			if config.Socks5User != "" {
 				log.Printf("SOCKS5 Auth: %s", config.Socks5User)
 			}
+		case "sni-route":
+			log.Printf("SNI Routing: %s:%s (%d routes)", config.LocalIP, config.LocalPort, len(config.SNIRoutes))
 		case "reverse-socks5":
 			log.Printf("Reverse SOCKS5 Proxy: %s:%s", config.RemoteIP, config.RemotePort)
 			if config.Socks5User != "" {
@@ -264,23 +250,30 @@ func handleMenuItemClick(menuItem *systray.MenuItem, config *ForwardConfig) {
 	}
 }
 
-func handleConnection(config *ForwardConfig, commonConfig *CommonConfig) {
+func handleConnection(ctx context.Context, config *ForwardConfig, commonConfig *CommonConfig) {
+	failures := 0
 	for {
 		select {
 		case <-ctx.Done():
 			return
 		default:
-			err := connectAndForward(config, commonConfig)
-			if err != nil {
-				log.Printf("Error in connection for %s: %v. Retrying in 30 seconds...", config.SectionName, err)
+			err := connectAndForward(ctx, config, commonConfig)
+			if err == nil {
+				failures = 0
+				continue
+			}
+			if ctx.Err() == nil {
+				failures++
+				delay := retryDelay(config, failures)
+				log.Printf("Error in connection for %s: %v. Retrying in %s...", config.SectionName, err, delay)
+				emitEvent(Event{Type: "error", Forward: config.SectionName, Server: config.ActiveServer(), Error: err.Error(), Labels: config.Labels})
 
 				// Remove the failed connection so it can be recreated
-				connManager.RemoveConnection(config.ServerName)
+				if conn := config.ActiveConnection(); conn != nil {
+					connManager.RemoveClient(conn)
+				}
 
-				select {
-				case <-time.After(30 * time.Second):
-					continue
-				case <-ctx.Done():
+				if !waitRetry(ctx, config, delay) {
 					return
 				}
 			}
@@ -288,24 +281,41 @@ func handleConnection(config *ForwardConfig, commonConfig *CommonConfig) {
 	}
 }
 
-func connectAndForward(config *ForwardConfig, commonConfig *CommonConfig) error {
-	// Get shared SSH connection
-	conn, err := connManager.GetConnection(config.ServerName)
+func connectAndForward(ctx context.Context, config *ForwardConfig, commonConfig *CommonConfig) error {
+	// Get shared SSH connection, failing over between the configured servers
+	conn, serverName, err := getForwardConnection(config)
 	if err != nil {
 		return fmt.Errorf("failed to get connection for %s: %v", config.ServerName, err)
 	}
 
-	log.Printf("Using shared connection to %s for %s", config.SSHConfig.Server, config.SectionName)
+	// Logged from the connection itself, as a reload may have removed the server since
+	log.Printf("Using shared connection to %s (%s) for %s", serverName, conn.RemoteAddr(), config.SectionName)
+
+	if config.PreConnectCommand != "" {
+		if err := runForwardCommand(conn, config, "preConnectCommand", config.PreConnectCommand); err != nil {
+			return fmt.Errorf("preConnectCommand failed for %s: %v", config.SectionName, err)
+		}
+	}
+	if config.PostConnectCommand != "" {
+		defer runPostConnectCommand(conn, config)
+	}
+
+	// Stop the forward as soon as the connection is found dead, so the
+	// reconnect loop restarts it on a fresh one
+	connCtx, stop := connManager.connectionContext(ctx, conn)
+	defer stop()
 
 	switch config.Direction {
 	case "remote":
-		err = handleRemotePortForward(conn, config, commonConfig)
+		err = handleRemotePortForward(connCtx, conn, config, commonConfig)
 	case "local":
-		err = handleLocalPortForward(conn, config, commonConfig)
+		err = handleLocalPortForward(connCtx, conn, config, commonConfig)
 	case "socks5":
-		err = handleSocks5Proxy(conn, config, commonConfig)
+		err = handleSocks5Proxy(connCtx, conn, config, commonConfig)
+	case "sni-route":
+		err = handleSNIRoute(connCtx, conn, config, commonConfig)
 	case "reverse-socks5":
-		err = handleReverseSocks5Proxy(conn, config, commonConfig)
+		err = handleReverseSocks5Proxy(connCtx, conn, config, commonConfig)
 	default:
 		return fmt.Errorf("invalid direction: %s", config.Direction)
 	}
@@ -313,15 +323,24 @@ func connectAndForward(config *ForwardConfig, commonConfig *CommonConfig) error
 	return err
 }
 
-func handleRemotePortForward(conn *ssh.Client, config *ForwardConfig, commonConfig *CommonConfig) error {
-	listener, err := conn.Listen("tcp", fmt.Sprintf("%s:%s", config.RemoteIP, config.RemotePort))
+func handleRemotePortForward(ctx context.Context, conn *ssh.Client, config *ForwardConfig, commonConfig *CommonConfig) error {
+	listener, err := listenRemotePorts(ctx, conn, config)
 	if err != nil {
 		return fmt.Errorf("failed to listen on remote server: %v", err)
 	}
 	defer listener.Close()
 
-	log.Printf("Listening on %s:%s for remote port forwarding", config.RemoteIP, config.RemotePort)
+	// Remember the bound address, which has the chosen port when 0 was configured
+	config.setListenAddr(listener.Addr().String())
+	defer config.setListenAddr("")
+
+	// Close the listener on shutdown or when the connection is lost
+	stop := context.AfterFunc(ctx, func() { listener.Close() })
+	defer stop()
 
+	log.Printf("Listening on %s for remote port forwarding", listener.Addr())
+
+	workers := startWorkerPool(ctx, config)
 	for {
 		select {
 		case <-ctx.Done():
@@ -329,23 +348,47 @@ func handleRemotePortForward(conn *ssh.Client, config *ForwardConfig, commonConf
 		default:
 			remoteConn, err := listener.Accept()
 			if err != nil {
+				// Listener closed by shutdown or a lost connection
+				if ctx.Err() != nil {
+					return nil
+				}
 				return fmt.Errorf("failed to accept connection: %v", err)
 			}
 
-			go handleForwardingConnection(remoteConn, config.LocalIP, config.LocalPort, commonConfig)
+			channel := connManager.trackChannel(conn, remoteConn)
+			workers.run(ctx, channel, traceConnection(commonConfig, config, channel, func() {
+				handleForwardingConnection(channel, config, commonConfig)
+			}))
 		}
 	}
 }
 
-func handleLocalPortForward(conn *ssh.Client, config *ForwardConfig, commonConfig *CommonConfig) error {
-	listener, err := net.Listen("tcp", fmt.Sprintf("%s:%s", config.LocalIP, config.LocalPort))
+func handleLocalPortForward(ctx context.Context, conn *ssh.Client, config *ForwardConfig, commonConfig *CommonConfig) error {
+	listener, err := listenLocal(config)
 	if err != nil {
 		return fmt.Errorf("failed to listen on local address: %v", err)
 	}
+	listener = wrapListenerTLS(wrapListenerDSCP(wrapListenerRate(wrapListenerSource(listener, config), config), config), config)
 	defer listener.Close()
 
-	log.Printf("Listening on %s:%s for local port forwarding", config.LocalIP, config.LocalPort)
+	// Remember the bound address, which has the chosen port when 0 was configured
+	config.setListenAddr(listener.Addr().String())
+	defer config.setListenAddr("")
 
+	// Close the listener on shutdown or when the connection is lost
+	stop := context.AfterFunc(ctx, func() { listener.Close() })
+	defer stop()
+
+	if config.tlsConfig != nil {
+		log.Printf("Listening on %s for local port forwarding (TLS)", listener.Addr())
+	} else {
+		log.Printf("Listening on %s for local port forwarding", listener.Addr())
+	}
+	if config.HealthCheck > 0 {
+		go runHealthCheck(ctx, config, commonConfig)
+	}
+
+	workers := startWorkerPool(ctx, config)
 	for {
 		select {
 		case <-ctx.Done():
@@ -353,45 +396,87 @@ func handleLocalPortForward(conn *ssh.Client, config *ForwardConfig, commonConfi
 		default:
 			localConn, err := listener.Accept()
 			if err != nil {
+				// Listener closed by shutdown or a lost connection
+				if ctx.Err() != nil {
+					return nil
+				}
 				return fmt.Errorf("failed to accept connection: %v", err)
 			}
 
-			go func() {
-				remoteConn, err := conn.Dial("tcp", fmt.Sprintf("%s:%s", config.RemoteIP, config.RemotePort))
+			workers.run(ctx, localConn, traceConnection(commonConfig, config, localConn, func() {
+				// Terminate TLS before opening a channel, the remote end gets plaintext
+				if err := completeHandshake(ctx, localConn); err != nil {
+					connLogf(localConn, "TLS handshake with %s failed for %s: %v", localConn.RemoteAddr(), config.SectionName, err)
+					localConn.Close()
+					return
+				}
+
+				// Bound the dial so a stalled channel open doesn't hold the client
+				start := time.Now()
+				target := config.remoteTarget()
+				dialCtx, cancel := context.WithTimeout(ctx, targetDialTimeout(config))
+				dial := func() (net.Conn, error) {
+					return dialChannel(dialCtx, conn, config, target, commonConfig)
+				}
+				remoteConn, err := dial()
+				if err == nil && config.CompressRelay {
+					remoteConn, err = negotiateCompression(config, remoteConn, dial)
+				}
+				cancel()
 				if err != nil {
-					log.Printf("Failed to connect to remote address: %v", err)
+					connLogf(localConn, "Failed to connect to remote address: %v", err)
 					localConn.Close()
 					return
 				}
 
-				go copyConn(localConn, remoteConn, commonConfig)
-				go copyConn(remoteConn, localConn, commonConfig)
-			}()
+				sent, received := relay(localConn, remoteConn, config, commonConfig)
+				emitTransfer(config, localConn.RemoteAddr(), target, start, sent, received)
+			}))
 		}
 	}
 }
 
-func handleForwardingConnection(incomingConn net.Conn, targetIP, targetPort string, commonConfig *CommonConfig) {
-	targetConn, err := net.Dial("tcp", fmt.Sprintf("%s:%s", targetIP, targetPort))
+func handleForwardingConnection(incomingConn net.Conn, config *ForwardConfig, commonConfig *CommonConfig) {
+	start := time.Now()
+	target := net.JoinHostPort(config.LocalIP, config.LocalPort)
+	targetConn, err := dscpDialer(config).Dial("tcp", target)
 	if err != nil {
-		log.Printf("Failed to connect to target address: %v", err)
+		connLogf(incomingConn, "Failed to connect to target address: %v", err)
 		incomingConn.Close()
 		return
 	}
+	warnSlowDial(target+" for "+config.SectionName, start, commonConfig.SlowDialThreshold)
 
-	go copyConn(targetConn, incomingConn, commonConfig)
-	go copyConn(incomingConn, targetConn, commonConfig)
+	sent, received := relay(incomingConn, targetConn, config, commonConfig)
+	emitTransfer(config, incomingConn.RemoteAddr(), target, start, sent, received)
 }
 
-func handleSocks5Proxy(conn *ssh.Client, config *ForwardConfig, commonConfig *CommonConfig) error {
-	listener, err := net.Listen("tcp", fmt.Sprintf("%s:%s", config.LocalIP, config.LocalPort))
+func handleSocks5Proxy(ctx context.Context, conn *ssh.Client, config *ForwardConfig, commonConfig *CommonConfig) error {
+	listener, err := listenLocal(config)
 	if err != nil {
 		return fmt.Errorf("failed to listen on local address: %v", err)
 	}
+	listener = wrapListenerTLS(wrapListenerDSCP(wrapListenerRate(wrapListenerSource(listener, config), config), config), config)
 	defer listener.Close()
 
-	log.Printf("SOCKS5 proxy listening on %s:%s", config.LocalIP, config.LocalPort)
+	// Remember the bound address, which has the chosen port when 0 was configured
+	config.setListenAddr(listener.Addr().String())
+	defer config.setListenAddr("")
+
+	// Close the listener on shutdown or when the connection is lost
+	stop := context.AfterFunc(ctx, func() { listener.Close() })
+	defer stop()
+
+	if config.tlsConfig != nil {
+		log.Printf("SOCKS5 proxy listening on %s (TLS)", listener.Addr())
+	} else {
+		log.Printf("SOCKS5 proxy listening on %s", listener.Addr())
+	}
+	if config.HealthCheck > 0 {
+		go runHealthCheck(ctx, config, commonConfig)
+	}
 
+	workers := startWorkerPool(ctx, config)
 	for {
 		select {
 		case <-ctx.Done():
@@ -399,37 +484,53 @@ func handleSocks5Proxy(conn *ssh.Client, config *ForwardConfig, commonConfig *Co
 		default:
 			clientConn, err := listener.Accept()
 			if err != nil {
+				// Listener closed by shutdown or a lost connection
+				if ctx.Err() != nil {
+					return nil
+				}
 				return fmt.Errorf("failed to accept connection: %v", err)
 			}
 
-			go handleSocks5Connection(clientConn, conn, config, commonConfig)
+			workers.run(ctx, clientConn, traceConnection(commonConfig, config, clientConn, func() {
+				handleSocks5Connection(ctx, clientConn, conn, config, commonConfig)
+			}))
 		}
 	}
 }
 
-func handleSocks5Connection(clientConn net.Conn, sshConn *ssh.Client, config *ForwardConfig, commonConfig *CommonConfig) {
+func handleSocks5Connection(ctx context.Context, clientConn net.Conn, sshConn *ssh.Client, config *ForwardConfig, commonConfig *CommonConfig) {
 	defer clientConn.Close()
 
 	socks5Server := &socks5Server{
+		ctx:     ctx,
 		sshConn: sshConn,
 		config:  config,
 	}
 
 	err := socks5Server.handleConnection(clientConn, commonConfig)
 	if err != nil {
-		log.Printf("SOCKS5 connection error: %v", err)
+		connLogf(clientConn, "SOCKS5 connection error: %v", err)
 	}
 }
 
-func handleReverseSocks5Proxy(conn *ssh.Client, config *ForwardConfig, commonConfig *CommonConfig) error {
-	listener, err := conn.Listen("tcp", fmt.Sprintf("%s:%s", config.RemoteIP, config.RemotePort))
+func handleReverseSocks5Proxy(ctx context.Context, conn *ssh.Client, config *ForwardConfig, commonConfig *CommonConfig) error {
+	listener, err := listenRemote(ctx, conn, config.RemoteIP, config.RemotePort)
 	if err != nil {
 		return fmt.Errorf("failed to listen on remote server: %v", err)
 	}
 	defer listener.Close()
 
-	log.Printf("Reverse SOCKS5 proxy listening on remote %s:%s", config.RemoteIP, config.RemotePort)
+	// Remember the bound address, which has the chosen port when 0 was configured
+	config.setListenAddr(listener.Addr().String())
+	defer config.setListenAddr("")
+
+	// Close the listener on shutdown or when the connection is lost
+	stop := context.AfterFunc(ctx, func() { listener.Close() })
+	defer stop()
 
+	log.Printf("Reverse SOCKS5 proxy listening on remote %s", listener.Addr())
+
+	workers := startWorkerPool(ctx, config)
 	for {
 		select {
 		case <-ctx.Done():
@@ -437,32 +538,33 @@ func handleReverseSocks5Proxy(conn *ssh.Client, config *ForwardConfig, commonCon
 		default:
 			remoteConn, err := listener.Accept()
 			if err != nil {
+				// Listener closed by shutdown or a lost connection
+				if ctx.Err() != nil {
+					return nil
+				}
 				return fmt.Errorf("failed to accept connection: %v", err)
 			}
 
-			go handleReverseSocks5Connection(remoteConn, config, commonConfig)
+			channel := connManager.trackChannel(conn, remoteConn)
+			workers.run(ctx, channel, traceConnection(commonConfig, config, channel, func() {
+				handleReverseSocks5Connection(ctx, channel, conn, config, commonConfig)
+			}))
 		}
 	}
 }
 
-func handleReverseSocks5Connection(remoteConn net.Conn, config *ForwardConfig, commonConfig *CommonConfig) {
+func handleReverseSocks5Connection(ctx context.Context, remoteConn net.Conn, sshConn *ssh.Client, config *ForwardConfig, commonConfig *CommonConfig) {
 	defer remoteConn.Close()
 
-	reverseSocks5Server := &reverseSocks5Server{config: config}
+	reverseSocks5Server := &reverseSocks5Server{
+		ctx:     ctx,
+		sshConn: sshConn,
+		config:  config,
+	}
 
 	err := reverseSocks5Server.handleConnection(remoteConn, commonConfig)
 	if err != nil {
-		log.Printf("Reverse SOCKS5 connection error: %v", err)
-	}
-}
-
-func copyConn(dst io.WriteCloser, src io.ReadCloser, commonConfig *CommonConfig) {
-	defer dst.Close()
-	defer src.Close()
-
-	_, err := io.Copy(dst, src)
-	if err != nil && err != io.EOF && commonConfig.Debug {
-		log.Printf("Data transfer error: %v", err)
+		connLogf(remoteConn, "Reverse SOCKS5 connection error: %v", err)
 	}
 }
 
@@ -470,408 +572,12 @@ func copyConn(dst io.WriteCloser, src io.ReadCloser, commonConfig *CommonConfig)
 func getIcon(path string) []byte {
 	data, err := os.ReadFile(path)
 	if err != nil {
-		log.Printf("Failed to read icon file: %v", err)
-		return nil
-	}
-	return data
-}
-
-// SOCKS5 server method implementations
-func (s *socks5Server) handleConnection(clientConn net.Conn, commonConfig *CommonConfig) error {
-	// Read SOCKS5 version and number of authentication methods
-	buf := make([]byte, 256)
-	n, err := clientConn.Read(buf)
-	if err != nil {
-		return fmt.Errorf("failed to read SOCKS5 greeting: %v", err)
-	}
-
-	if n < 2 || buf[0] != 0x05 {
-		return fmt.Errorf("invalid SOCKS5 version")
-	}
-
-	// Check if authentication is required
-	requireAuth := s.config.Socks5User != "" && s.config.Socks5Pass != ""
-
-	// Parse supported authentication methods
-	numMethods := int(buf[1])
-	if n < 2+numMethods {
-		return fmt.Errorf("invalid authentication methods")
-	}
-
-	supportedMethods := buf[2 : 2+numMethods]
-	var selectedMethod byte = 0xFF // No acceptable methods
-
-	if requireAuth {
-		// Check if client supports username/password authentication (method 0x02)
-		for _, method := range supportedMethods {
-			if method == 0x02 {
-				selectedMethod = 0x02
-				break
-			}
-		}
-	} else {
-		// Check if client supports no authentication (method 0x00)
-		for _, method := range supportedMethods {
-			if method == 0x00 {
-				selectedMethod = 0x00
-				break
-			}
-		}
-	}
-
-	// Send authentication method selection response
-	_, err = clientConn.Write([]byte{0x05, selectedMethod})
-	if err != nil {
-		return fmt.Errorf("failed to send auth method response: %v", err)
-	}
-
-	if selectedMethod == 0xFF {
-		return fmt.Errorf("no acceptable authentication methods")
-	}
-
-	// Handle authentication if required
-	if selectedMethod == 0x02 {
-		err = s.handleUsernamePasswordAuth(clientConn, commonConfig)
-		if err != nil {
-			return fmt.Errorf("authentication failed: %v", err)
+		if !os.IsNotExist(err) {
+			log.Printf("Failed to read icon file: %v", err)
 		}
+		return defaultIcon
 	}
-
-	// Read connection request
-	n, err = clientConn.Read(buf)
-	if err != nil {
-		return fmt.Errorf("failed to read connection request: %v", err)
-	}
-
-	if n < 4 || buf[0] != 0x05 || buf[1] != 0x01 {
-		return fmt.Errorf("invalid SOCKS5 connection request")
-	}
-
-	// Parse target address
-	var targetAddr string
-	var targetPort uint16
-
-	switch buf[3] { // Address type
-	case 0x01: // IPv4
-		if n < 10 {
-			return fmt.Errorf("invalid IPv4 address length")
-		}
-		targetAddr = fmt.Sprintf("%d.%d.%d.%d", buf[4], buf[5], buf[6], buf[7])
-		targetPort = uint16(buf[8])<<8 | uint16(buf[9])
-	case 0x03: // Domain name
-		if n < 5 {
-			return fmt.Errorf("invalid domain name length")
-		}
-		domainLen := int(buf[4])
-		if n < 5+domainLen+2 {
-			return fmt.Errorf("incomplete domain name")
-		}
-		targetAddr = string(buf[5 : 5+domainLen])
-		targetPort = uint16(buf[5+domainLen])<<8 | uint16(buf[5+domainLen+1])
-	case 0x04: // IPv6
-		if n < 22 {
-			return fmt.Errorf("invalid IPv6 address length")
-		}
-		// IPv6 address parsing
-		ipv6 := net.IP(buf[4:20])
-		targetAddr = ipv6.String()
-		targetPort = uint16(buf[20])<<8 | uint16(buf[21])
-	default:
-		return fmt.Errorf("unsupported address type: %d", buf[3])
-	}
-
-	target := fmt.Sprintf("%s:%d", targetAddr, targetPort)
-
-	// Connect to target through SSH tunnel
-	remoteConn, err := s.sshConn.Dial("tcp", target)
-	if err != nil {
-		// Send connection failed response
-		response := []byte{0x05, 0x05, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}
-		clientConn.Write(response)
-		return fmt.Errorf("failed to connect to target %s: %v", target, err)
-	}
-	defer remoteConn.Close()
-
-	// Send success response
-	response := []byte{0x05, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}
-	_, err = clientConn.Write(response)
-	if err != nil {
-		return fmt.Errorf("failed to send success response: %v", err)
-	}
-
-	if commonConfig.Debug {
-		log.Printf("SOCKS5 connection established to %s", target)
-	}
-
-	// Start bidirectional data transfer and wait for completion
-	done := make(chan bool, 2)
-
-	go func() {
-		copyConn(clientConn, remoteConn, commonConfig)
-		done <- true
-	}()
-
-	go func() {
-		copyConn(remoteConn, clientConn, commonConfig)
-		done <- true
-	}()
-
-	// Wait for either direction to complete
-	<-done
-
-	return nil
-}
-
-func (s *socks5Server) handleUsernamePasswordAuth(clientConn net.Conn, commonConfig *CommonConfig) error {
-	buf := make([]byte, 256)
-	n, err := clientConn.Read(buf)
-	if err != nil {
-		return fmt.Errorf("failed to read auth request: %v", err)
-	}
-
-	if n < 2 || buf[0] != 0x01 {
-		return fmt.Errorf("invalid auth version")
-	}
-
-	// Parse username
-	userLen := int(buf[1])
-	if n < 2+userLen+1 {
-		return fmt.Errorf("invalid username length")
-	}
-	username := string(buf[2 : 2+userLen])
-
-	// Parse password
-	passLen := int(buf[2+userLen])
-	if n < 2+userLen+1+passLen {
-		return fmt.Errorf("invalid password length")
-	}
-	password := string(buf[2+userLen+1 : 2+userLen+1+passLen])
-
-	// Verify credentials
-	if username == s.config.Socks5User && password == s.config.Socks5Pass {
-		// Authentication successful
-		_, err = clientConn.Write([]byte{0x01, 0x00})
-		if err != nil {
-			return fmt.Errorf("failed to send auth success: %v", err)
-		}
-		if commonConfig.Debug {
-			log.Printf("SOCKS5 authentication successful for user: %s", username)
-		}
-		return nil
-	} else {
-		// Authentication failed
-		_, err = clientConn.Write([]byte{0x01, 0x01})
-		if err != nil {
-			return fmt.Errorf("failed to send auth failure: %v", err)
-		}
-		return fmt.Errorf("invalid credentials for user: %s", username)
-	}
-}
-
-func (s *reverseSocks5Server) handleConnection(clientConn net.Conn, commonConfig *CommonConfig) error {
-	// Read SOCKS5 version and number of authentication methods
-	buf := make([]byte, 256)
-	n, err := clientConn.Read(buf)
-	if err != nil {
-		return fmt.Errorf("failed to read SOCKS5 greeting: %v", err)
-	}
-
-	if n < 2 || buf[0] != 0x05 {
-		return fmt.Errorf("invalid SOCKS5 version")
-	}
-
-	// Check if authentication is required
-	requireAuth := s.config.Socks5User != "" && s.config.Socks5Pass != ""
-
-	// Parse supported authentication methods
-	numMethods := int(buf[1])
-	if n < 2+numMethods {
-		return fmt.Errorf("invalid authentication methods")
-	}
-
-	supportedMethods := buf[2 : 2+numMethods]
-	var selectedMethod byte = 0xFF // No acceptable methods
-
-	if requireAuth {
-		// Check if client supports username/password authentication (method 0x02)
-		for _, method := range supportedMethods {
-			if method == 0x02 {
-				selectedMethod = 0x02
-				break
-			}
-		}
-	} else {
-		// Check if client supports no authentication (method 0x00)
-		for _, method := range supportedMethods {
-			if method == 0x00 {
-				selectedMethod = 0x00
-				break
-			}
-		}
-	}
-
-	// Send authentication method selection response
-	_, err = clientConn.Write([]byte{0x05, selectedMethod})
-	if err != nil {
-		return fmt.Errorf("failed to send auth method response: %v", err)
-	}
-
-	if selectedMethod == 0xFF {
-		return fmt.Errorf("no acceptable authentication methods")
-	}
-
-	// Handle authentication if required
-	if selectedMethod == 0x02 {
-		err = s.handleUsernamePasswordAuth(clientConn, commonConfig)
-		if err != nil {
-			return fmt.Errorf("authentication failed: %v", err)
-		}
-	}
-
-	// Read connection request
-	n, err = clientConn.Read(buf)
-	if err != nil {
-		return fmt.Errorf("failed to read connection request: %v", err)
-	}
-
-	if n < 4 || buf[0] != 0x05 || buf[1] != 0x01 {
-		return fmt.Errorf("invalid SOCKS5 connection request")
-	}
-
-	// Parse target address
-	var targetAddr string
-	var targetPort uint16
-
-	switch buf[3] { // Address type
-	case 0x01: // IPv4
-		if n < 10 {
-			return fmt.Errorf("invalid IPv4 address length")
-		}
-		targetAddr = fmt.Sprintf("%d.%d.%d.%d", buf[4], buf[5], buf[6], buf[7])
-		targetPort = uint16(buf[8])<<8 | uint16(buf[9])
-	case 0x03: // Domain name
-		if n < 5 {
-			return fmt.Errorf("invalid domain name length")
-		}
-		domainLen := int(buf[4])
-		if n < 5+domainLen+2 {
-			return fmt.Errorf("incomplete domain name")
-		}
-		targetAddr = string(buf[5 : 5+domainLen])
-		targetPort = uint16(buf[5+domainLen])<<8 | uint16(buf[5+domainLen+1])
-	case 0x04: // IPv6
-		if n < 22 {
-			return fmt.Errorf("invalid IPv6 address length")
-		}
-		// IPv6 address parsing
-		ipv6 := net.IP(buf[4:20])
-		targetAddr = ipv6.String()
-		targetPort = uint16(buf[20])<<8 | uint16(buf[21])
-	default:
-		return fmt.Errorf("unsupported address type: %d", buf[3])
-	}
-
-	target := fmt.Sprintf("%s:%d", targetAddr, targetPort)
-
-	// Add DNS resolution debugging for domain names
-	if buf[3] == 0x03 { // Domain name
-		_, err := net.LookupIP(targetAddr)
-		if err != nil {
-			log.Printf("Reverse SOCKS5 DNS resolution failed for %s: %v", targetAddr, err)
-		}
-	}
-
-	// For reverse SOCKS5, we need to connect through the local machine's internet connection
-	// This allows the remote server to access the internet through our local connection
-	dialer := &net.Dialer{
-		Timeout: 30 * time.Second,
-	}
-	localConn, err := dialer.Dial("tcp", target)
-	if err != nil {
-		if commonConfig.Debug {
-			log.Printf("Reverse SOCKS5 connection failed to %s: %v", target, err)
-		}
-		// Send connection failed response
-		response := []byte{0x05, 0x05, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}
-		clientConn.Write(response)
-		return fmt.Errorf("failed to connect to target %s through local connection: %v", target, err)
-	}
-	defer localConn.Close()
-
-	// Send success response
-	response := []byte{0x05, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}
-	_, err = clientConn.Write(response)
-	if err != nil {
-		return fmt.Errorf("failed to send success response: %v", err)
-	}
-
-	if commonConfig.Debug {
-		log.Printf("Reverse SOCKS5 connection established: %s", target)
-	}
-
-	// Start bidirectional data transfer and wait for completion
-	done := make(chan bool, 2)
-
-	go func() {
-		copyConn(clientConn, localConn, commonConfig)
-		done <- true
-	}()
-
-	go func() {
-		copyConn(localConn, clientConn, commonConfig)
-		done <- true
-	}()
-
-	// Wait for either direction to complete
-	<-done
-
-	return nil
-}
-
-func (s *reverseSocks5Server) handleUsernamePasswordAuth(clientConn net.Conn, commonConfig *CommonConfig) error {
-	buf := make([]byte, 256)
-	n, err := clientConn.Read(buf)
-	if err != nil {
-		return fmt.Errorf("failed to read auth request: %v", err)
-	}
-
-	if n < 2 || buf[0] != 0x01 {
-		return fmt.Errorf("invalid auth version")
-	}
-
-	// Parse username
-	userLen := int(buf[1])
-	if n < 2+userLen+1 {
-		return fmt.Errorf("invalid username length")
-	}
-	username := string(buf[2 : 2+userLen])
-
-	// Parse password
-	passLen := int(buf[2+userLen])
-	if n < 2+userLen+1+passLen {
-		return fmt.Errorf("invalid password length")
-	}
-	password := string(buf[2+userLen+1 : 2+userLen+1+passLen])
-
-	// Verify credentials
-	if username == s.config.Socks5User && password == s.config.Socks5Pass {
-		// Authentication successful
-		_, err = clientConn.Write([]byte{0x01, 0x00})
-		if err != nil {
-			return fmt.Errorf("failed to send auth success: %v", err)
-		}
-		if commonConfig.Debug {
-			log.Printf("Reverse SOCKS5 authentication successful for user: %s", username)
-		}
-		return nil
-	} else {
-		// Authentication failed
-		_, err = clientConn.Write([]byte{0x01, 0x01})
-		if err != nil {
-			return fmt.Errorf("failed to send auth failure: %v", err)
-		}
-		return fmt.Errorf("invalid credentials for user: %s", username)
-	}
+	return data
 }
 
 func handleQuitMenuItemClick(menuItem *systray.MenuItem) {
@@ -897,61 +603,103 @@ func handleReloadConfigMenuItemClick(menuItem *systray.MenuItem) {
 
 // Connection manager methods
 func (cm *ConnectionManager) GetConnection(serverName string) (*ssh.Client, error) {
+	// Servers with a connection pool hand out their least busy connection
+	key := cm.nextPoolKey(serverName)
+
 	cm.mutex.RLock()
-	if conn, exists := cm.connections[serverName]; exists && conn != nil {
+	if conn, exists := cm.connections[key]; exists && conn != nil {
 		cm.mutex.RUnlock()
 		return conn, nil
 	}
 	cm.mutex.RUnlock()
 
-	// Connection doesn't exist, create it
-	return cm.createConnection(serverName)
+	// Connection doesn't exist, create it together with any other callers
+	// waiting for the same one
+	return cm.coalesceDial(key, func() (*ssh.Client, error) {
+		return cm.createConnection(key)
+	})
 }
 
 func (cm *ConnectionManager) createConnection(serverName string) (*ssh.Client, error) {
-	cm.mutex.Lock()
-	defer cm.mutex.Unlock()
-
-	// Double-check after acquiring write lock
+	// Double-check, another caller may have connected in the meantime
+	cm.mutex.RLock()
 	if conn, exists := cm.connections[serverName]; exists && conn != nil {
+		cm.mutex.RUnlock()
 		return conn, nil
 	}
+	cm.mutex.RUnlock()
 
-	// Get server config
-	serverConfig, ok := servers[serverName]
-	if !ok {
+	// Get server config, for pooled connections that of the pool's server
+	serverConfig := lookupServer(poolServerName(serverName))
+	if serverConfig == nil {
 		return nil, fmt.Errorf("server configuration not found for %s", serverName)
 	}
 
+	auth, err := newSSHAuth(serverConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set up authentication for %s: %v", serverName, err)
+	}
+	defer auth.Close()
+
 	// Create SSH config
 	sshConfig := &ssh.ClientConfig{
-		User: serverConfig.User,
-		Auth: []ssh.AuthMethod{
-			ssh.Password(serverConfig.Password),
-		},
+		User:            serverConfig.User,
+		Auth:            auth.Methods,
 		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
 		Timeout:         10 * time.Second,
+		Config:          algorithmConfig(serverConfig),
+		BannerCallback:  bannerCallback(serverName, serverConfig),
 	}
 
-	// Establish connection
-	conn, err := ssh.Dial("tcp", fmt.Sprintf("%s:%s", serverConfig.Server, serverConfig.Port), sshConfig)
+	// Establish connection without holding the lock, so other servers and
+	// status queries are not held up by a slow dial
+	cm.mutex.Lock()
+	cm.beginDial(serverName)
+	cm.mutex.Unlock()
+	dialStart := time.Now()
+	conn, err := dialServer(serverConfig, sshConfig)
 	if err != nil {
+		cm.mutex.Lock()
+		cm.setState(serverName, stateFailed)
+		cm.mutex.Unlock()
 		return nil, fmt.Errorf("failed to dial %s: %v", serverName, err)
 	}
+	warnSlowDial("server "+serverName, dialStart, serverConfig.slowDial)
 
 	// Store connection
+	cm.mutex.Lock()
 	cm.connections[serverName] = conn
+	cm.trackConnection(conn)
+	cm.recordConnect(serverName, conn)
+	cm.signalConnected()
+	cm.mutex.Unlock()
+	if serverConfig.warnOld {
+		warnOldServer(serverName, string(conn.ServerVersion()))
+	}
 
-	// Start connection monitor
-	go cm.monitorConnection(serverName, conn)
+	// Start connection monitor, unless disabled for short-lived use where
+	// failed dials are enough to notice a dead connection
+	if serverConfig.Monitor {
+		go cm.monitorConnection(serverName, conn)
+	}
+
+	log.Printf("Created shared SSH connection for server: %s (authenticated with %s)", serverName, auth.Used())
+	emitEvent(Event{Type: "connect", Server: serverName})
 
-	log.Printf("Created shared SSH connection for server: %s", serverName)
+	// Checking the account once is enough: reconnects use the same credentials
+	if serverConfig.VerifyUser {
+		serverConfig.verifyOnce.Do(func() {
+			go verifyRemoteUser(serverName, serverConfig, conn)
+		})
+	}
 	return conn, nil
 }
 
 func (cm *ConnectionManager) monitorConnection(serverName string, conn *ssh.Client) {
 	ticker := time.NewTicker(30 * time.Second)
 	defer ticker.Stop()
+	connectedAt := time.Now()
+	missed := 0 // consecutive unanswered keepalives
 
 	for {
 		select {
@@ -961,12 +709,23 @@ func (cm *ConnectionManager) monitorConnection(serverName string, conn *ssh.Clie
 				log.Printf("SSH connection lost for server: %s", serverName)
 				goto cleanup
 			}
-			// Send a keep-alive ping
-			_, _, err := conn.SendRequest("keepalive@openssh.com", true, nil)
-			if err != nil {
+			// Send a keep-alive ping, tolerating a few unanswered ones
+			if err := sendKeepalive(serverName, conn); err != nil {
+				if err == errKeepaliveTimeout {
+					missed++
+					if cm.tolerateMissedKeepalive(serverName, missed) {
+						continue
+					}
+				}
 				log.Printf("SSH connection failed for server: %s: %v", serverName, err)
 				goto cleanup
 			}
+			missed = 0
+			cm.recordKeepalive(serverName)
+			cm.logChannels(serverName, conn)
+			if cm.shouldRotate(serverName, conn, connectedAt) {
+				goto cleanup
+			}
 		case <-cm.ctx.Done():
 			log.Printf("Context cancelled, closing SSH connection for server: %s", serverName)
 			goto cleanup
@@ -974,10 +733,16 @@ func (cm *ConnectionManager) monitorConnection(serverName string, conn *ssh.Clie
 	}
 
 cleanup:
-	// Remove connection from map
+	// Close the connection so forwards using it restart, and remove it from
+	// the map unless it has already been replaced
 	cm.mutex.Lock()
-	delete(cm.connections, serverName)
+	cm.markLost(conn)
+	if cm.connections[serverName] == conn {
+		delete(cm.connections, serverName)
+		cm.setState(serverName, stateReconnecting)
+	}
 	cm.mutex.Unlock()
+	emitEvent(Event{Type: "disconnect", Server: serverName})
 }
 
 func (cm *ConnectionManager) CloseAll() {
@@ -986,24 +751,14 @@ func (cm *ConnectionManager) CloseAll() {
 
 	for serverName, conn := range cm.connections {
 		if conn != nil {
-			conn.Close()
+			cm.markLost(conn)
 			log.Printf("Closed SSH connection for server: %s", serverName)
+			emitEvent(Event{Type: "disconnect", Server: serverName})
 		}
 	}
 	cm.connections = make(map[string]*ssh.Client)
 }
 
-func (cm *ConnectionManager) RemoveConnection(serverName string) {
-	cm.mutex.Lock()
-	defer cm.mutex.Unlock()
-
-	if conn, exists := cm.connections[serverName]; exists && conn != nil {
-		conn.Close()
-		log.Printf("Removed failed SSH connection for server: %s", serverName)
-	}
-	delete(cm.connections, serverName)
-}
-
 // Helper functions for connection status
 func getConnectionStatus(serverName string) bool {
 	if connManager == nil {
@@ -1017,8 +772,7 @@ func getConnectionStatus(serverName string) bool {
 		// Check if connection is still alive
 		if conn.Conn != nil {
 			// Try to send a keep-alive ping
-			_, _, err := conn.SendRequest("keepalive@openssh.com", true, nil)
-			return err == nil
+			return sendKeepalive(serverName, conn) == nil
 		}
 	}
 	return false
@@ -0,0 +1,80 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Escapes of Prometheus label values
+var metricValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// metricLabels renders name/value pairs, followed by the forward labels
+// extra, as a Prometheus label set.
+func metricLabels(extra map[string]string, pairs ...string) string {
+	var parts []string
+	for i := 0; i+1 < len(pairs); i += 2 {
+		parts = append(parts, fmt.Sprintf(`%s="%s"`, pairs[i], metricValueEscaper.Replace(pairs[i+1])))
+	}
+	for _, name := range sortedLabelNames(extra) {
+		parts = append(parts, fmt.Sprintf(`%s="%s"`, name, metricValueEscaper.Replace(extra[name])))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+func boolMetric(value bool) int {
+	if value {
+		return 1
+	}
+	return 0
+}
+
+// handleControlMetrics serves the status snapshot in the Prometheus text
+// format. Forward metrics carry the forward's labels.
+func handleControlMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	snapshot := getStatusSnapshot()
+
+	var b strings.Builder
+	b.WriteString("# HELP spf_server_connected Whether the server has an open SSH connection.\n")
+	b.WriteString("# TYPE spf_server_connected gauge\n")
+	for _, server := range snapshot.Servers {
+		fmt.Fprintf(&b, "spf_server_connected%s %d\n", metricLabels(nil, "server", server.Name), boolMetric(server.Connected))
+	}
+	b.WriteString("# HELP spf_server_state Connection state of the server, 1 for the current state.\n")
+	b.WriteString("# TYPE spf_server_state gauge\n")
+	for _, server := range snapshot.Servers {
+		for _, state := range serverStates {
+			fmt.Fprintf(&b, "spf_server_state%s %d\n", metricLabels(nil, "server", server.Name, "state", state), boolMetric(server.State == state))
+		}
+	}
+	b.WriteString("# HELP spf_server_reconnects_total SSH connections made to the server after the first.\n")
+	b.WriteString("# TYPE spf_server_reconnects_total counter\n")
+	for _, server := range snapshot.Servers {
+		fmt.Fprintf(&b, "spf_server_reconnects_total%s %d\n", metricLabels(nil, "server", server.Name), server.Reconnects)
+	}
+
+	b.WriteString("# HELP spf_forward_running Whether the forward is started.\n")
+	b.WriteString("# TYPE spf_forward_running gauge\n")
+	for _, forward := range snapshot.Forwards {
+		fmt.Fprintf(&b, "spf_forward_running%s %d\n",
+			metricLabels(forward.Labels, "forward", forward.Name, "direction", forward.Direction), boolMetric(forward.Running))
+	}
+	b.WriteString("# HELP spf_forward_health_ok Whether the forward's latest health check passed.\n")
+	b.WriteString("# TYPE spf_forward_health_ok gauge\n")
+	for _, forward := range snapshot.Forwards {
+		if forward.Health != nil {
+			fmt.Fprintf(&b, "spf_forward_health_ok%s %d\n",
+				metricLabels(forward.Labels, "forward", forward.Name, "direction", forward.Direction), boolMetric(forward.Health.OK))
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write([]byte(b.String()))
+}
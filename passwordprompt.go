@@ -0,0 +1,62 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+
+	"golang.org/x/term"
+)
+
+// Whether promptPassword can be used on this platform
+const promptPasswordSupported = true
+
+// Passwords entered at startup, by user@server:port, kept for reloads
+var (
+	promptedPasswords      = make(map[string]string)
+	promptedPasswordsMutex sync.Mutex
+)
+
+// promptPasswords fills in the password of servers with promptPassword and
+// no password in the config. At startup (interactive) it is read from the
+// terminal without echo; on reload only passwords entered at startup are
+// reused, as a reload must not block waiting for input.
+func promptPasswords(config *Config, interactive bool) error {
+	names := make([]string, 0, len(config.Servers))
+	for name, serverConfig := range config.Servers {
+		if serverConfig.PromptPassword && serverConfig.Password == "" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	promptedPasswordsMutex.Lock()
+	defer promptedPasswordsMutex.Unlock()
+	for _, name := range names {
+		serverConfig := config.Servers[name]
+		key := fmt.Sprintf("%s@%s:%s", serverConfig.User, serverConfig.Server, serverConfig.Port)
+		if password, ok := promptedPasswords[key]; ok {
+			serverConfig.Password = password
+			continue
+		}
+		if !interactive {
+			return fmt.Errorf("promptPassword for %s is only asked at startup; restart spf to enter it", name)
+		}
+		if !term.IsTerminal(int(os.Stdin.Fd())) {
+			return fmt.Errorf("promptPassword for %s needs a terminal on stdin", name)
+		}
+		fmt.Fprintf(os.Stderr, "Password for %s (%s@%s): ", name, serverConfig.User, serverConfig.Server)
+		password, err := term.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return fmt.Errorf("failed to read password for %s: %v", name, err)
+		}
+		promptedPasswords[key] = string(password)
+		serverConfig.Password = string(password)
+	}
+	return nil
+}
@@ -0,0 +1,5 @@
+package main
+
+// Whether promptPassword can be used on this platform; the tray application
+// has no terminal to ask on
+const promptPasswordSupported = false
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync/atomic"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// poolKey names slot i of a server's connection pool in the connection
+// manager. The first slot uses the plain server name.
+func poolKey(serverName string, slot int) string {
+	if slot == 0 {
+		return serverName
+	}
+	return fmt.Sprintf("%s#%d", serverName, slot+1)
+}
+
+// poolServerName returns the server a pool key belongs to.
+func poolServerName(key string) string {
+	if i := strings.LastIndex(key, "#"); i >= 0 {
+		return key[:i]
+	}
+	return key
+}
+
+// nextPoolKey picks the pool slot for the next GetConnection on a server:
+// the one of its connectionPoolSize connections with the fewest open
+// channels, where a slot not connected yet counts as idle. Ties go
+// round-robin.
+func (cm *ConnectionManager) nextPoolKey(serverName string) string {
+	serverConfig := lookupServer(serverName)
+	if serverConfig == nil || serverConfig.PoolSize <= 1 {
+		return serverName
+	}
+	start := int(atomic.AddUint32(&serverConfig.poolNext, 1)-1) % serverConfig.PoolSize
+
+	cm.mutex.RLock()
+	defer cm.mutex.RUnlock()
+	best, bestLoad := "", int64(-1)
+	for i := 0; i < serverConfig.PoolSize; i++ {
+		key := poolKey(serverName, (start+i)%serverConfig.PoolSize)
+		var load int64
+		if count := cm.channels[cm.connections[key]]; count != nil {
+			load = atomic.LoadInt64(count)
+		}
+		if bestLoad < 0 || load < bestLoad {
+			best, bestLoad = key, load
+		}
+	}
+	return best
+}
+
+// poolConnected counts the open connections in a server's pool.
+func (cm *ConnectionManager) poolConnected(serverName string, poolSize int) int {
+	cm.mutex.RLock()
+	defer cm.mutex.RUnlock()
+	connected := 0
+	for slot := 0; slot < poolSize; slot++ {
+		if cm.connections[poolKey(serverName, slot)] != nil {
+			connected++
+		}
+	}
+	return connected
+}
+
+// RemoveClient closes a failed connection and drops it from the manager, so
+// the next GetConnection for its pool slot dials a new one.
+func (cm *ConnectionManager) RemoveClient(conn *ssh.Client) {
+	cm.mutex.Lock()
+	defer cm.mutex.Unlock()
+
+	for key, c := range cm.connections {
+		if c == conn {
+			delete(cm.connections, key)
+			cm.setState(key, stateReconnecting)
+			log.Printf("Removed failed SSH connection for server: %s", key)
+			emitEvent(Event{Type: "disconnect", Server: key, Error: "connection failed"})
+		}
+	}
+	cm.markLost(conn)
+}
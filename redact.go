@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"net"
+)
+
+// Shown in place of a secret in logs and dumps
+const redactedValue = "<redacted>"
+
+// maskSecret returns what may be logged of a secret: nothing when it is
+// empty, a placeholder otherwise, so not even its length leaks.
+func maskSecret(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return redactedValue
+}
+
+// String describes the server with its password masked, so a ServerConfig
+// can be logged with %v.
+func (sc *ServerConfig) String() string {
+	return fmt.Sprintf("ServerConfig{Address: %s, User: %s, Password: %s, IdentityFile: %s}",
+		net.JoinHostPort(sc.Server, sc.Port), sc.User, maskSecret(sc.Password), sc.IdentityFile)
+}
+
+// GoString masks the password for %#v as well.
+func (sc *ServerConfig) GoString() string {
+	return sc.String()
+}
+
+// String describes the forward with its SOCKS5 credentials masked, so a
+// ForwardConfig can be logged with %v.
+func (fc *ForwardConfig) String() string {
+	return fmt.Sprintf("ForwardConfig{Section: %s, Direction: %s, Server: %s, Local: %s, Remote: %s, Socks5User: %s, Socks5Pass: %s, Socks5Users: %d}",
+		fc.SectionName, fc.Direction, fc.ServerName, net.JoinHostPort(fc.LocalIP, fc.LocalPort),
+		net.JoinHostPort(fc.RemoteIP, fc.RemotePort), fc.Socks5User, maskSecret(fc.Socks5Pass), len(fc.Socks5Users))
+}
+
+// GoString masks the credentials for %#v as well.
+func (fc *ForwardConfig) GoString() string {
+	return fc.String()
+}
+
+// String describes the common settings with the SOCKS5 password masked.
+func (cc *CommonConfig) String() string {
+	return fmt.Sprintf("CommonConfig{Debug: %t, ControlListen: %s, Socks5User: %s, Socks5Pass: %s}",
+		cc.Debug, cc.ControlListen, cc.Socks5User, maskSecret(cc.Socks5Pass))
+}
+
+// GoString masks the SOCKS5 password for %#v as well.
+func (cc *CommonConfig) GoString() string {
+	return cc.String()
+}
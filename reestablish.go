@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// Delay before a failed forward is retried
+const forwardRetryDelay = 30 * time.Second
+
+// First retry delay of remote and reverse-socks5 forwards, and of forwards
+// recovering together with their server, doubled on each failure up to
+// forwardRetryDelay
+const remoteRetryDelay = time.Second
+
+// retryDelay returns how long to wait after the forward failed failures times
+// in a row. Forwards with a remote listener start retrying quickly, so their
+// port reopens soon after a short outage of the SSH connection, and so do
+// forwards of a server with recoverTogether.
+func retryDelay(config *ForwardConfig, failures int) time.Duration {
+	if !followsReconnect(config) {
+		return forwardRetryDelay
+	}
+	delay := remoteRetryDelay
+	for i := 1; i < failures && delay < forwardRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > forwardRetryDelay {
+		delay = forwardRetryDelay
+	}
+	return delay
+}
+
+// waitRetry waits delay before the forward is retried and reports whether to
+// retry, false meaning ctx is done. A remote or reverse-socks5 forward is
+// retried early when one of its servers gets connected again, e.g. by
+// another forward, so its remote bind is requested right away. With
+// recoverTogether, every forward of the server is, so they all come back at
+// once instead of each on its own timer.
+func waitRetry(ctx context.Context, config *ForwardConfig, delay time.Duration) bool {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	follow := followsReconnect(config)
+	for {
+		var connected <-chan struct{}
+		if follow {
+			connected = connManager.connectedSignal()
+		}
+		select {
+		case <-timer.C:
+			return true
+		case <-ctx.Done():
+			return false
+		case <-connected:
+			if connManager.anyConnected(config.ServerNames) {
+				log.Printf("SSH connection for %s is back, retrying now", config.SectionName)
+				return true
+			}
+		}
+	}
+}
+
+// followsReconnect reports whether the forward retries quickly and as soon as
+// one of its servers is connected again: it has a remote listener, or one of
+// its servers sets recoverTogether.
+func followsReconnect(config *ForwardConfig) bool {
+	if config.Direction == "remote" || config.Direction == "reverse-socks5" {
+		return true
+	}
+	for _, name := range config.ServerNames {
+		if serverConfig := lookupServer(name); serverConfig != nil && serverConfig.RecoverTogether {
+			return true
+		}
+	}
+	return false
+}
+
+// connectedSignal returns a channel that is closed when the next SSH
+// connection is established.
+func (cm *ConnectionManager) connectedSignal() <-chan struct{} {
+	cm.mutex.RLock()
+	defer cm.mutex.RUnlock()
+	return cm.connected
+}
+
+// signalConnected wakes the waiters of connectedSignal. Callers hold
+// cm.mutex.
+func (cm *ConnectionManager) signalConnected() {
+	close(cm.connected)
+	cm.connected = make(chan struct{})
+}
+
+// anyConnected reports whether one of the servers has an open connection.
+func (cm *ConnectionManager) anyConnected(serverNames []string) bool {
+	cm.mutex.RLock()
+	defer cm.mutex.RUnlock()
+	for _, name := range serverNames {
+		if cm.connections[name] != nil {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// Bounds of a forward's channelBufferSize
+const (
+	minChannelBufferSize = 4 << 10
+	maxChannelBufferSize = 16 << 20
+)
+
+// relay copies data between a client and its target in both directions. When
+// one direction reaches EOF only the write side of its destination is closed
+// (half-close), so the peer sees EOF while it can still send. Both connections
+// are closed once both directions are done, or immediately when a copy fails or
+// half-close is not supported. Sent bytes flow from the client to the target.
+func relay(clientConn, targetConn net.Conn, config *ForwardConfig, commonConfig *CommonConfig) (sent, received int64) {
+	prefix := connPrefix(clientConn)
+	var closeOnce sync.Once
+	closeBoth := func() {
+		closeOnce.Do(func() {
+			clientConn.Close()
+			targetConn.Close()
+		})
+	}
+
+	// finish ends one direction after its copy returned
+	finish := func(dst net.Conn, err error) {
+		if err == nil {
+			if halfCloser, ok := dst.(interface{ CloseWrite() error }); ok && halfCloser.CloseWrite() == nil {
+				return
+			}
+		}
+		closeBoth()
+	}
+
+	// With throughputInterval the copies read through counters; otherwise
+	// the conns are used directly, keeping io.Copy's fast paths
+	var fromClient, fromTarget io.Reader = clientConn, targetConn
+	if commonConfig.ThroughputInterval > 0 {
+		sampler := startThroughputSampler(clientConn, config, commonConfig.ThroughputInterval)
+		defer sampler.stop()
+		fromClient = &countingReader{Reader: clientConn, bucket: &sampler.sent}
+		fromTarget = &countingReader{Reader: targetConn, bucket: &sampler.received}
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	go func() {
+		defer wg.Done()
+		var err error
+		sent, err = copyConn(targetConn, fromClient, config.ChannelBufferSize, prefix, commonConfig)
+		finish(targetConn, err)
+	}()
+
+	go func() {
+		defer wg.Done()
+		var err error
+		received, err = copyConn(clientConn, fromTarget, config.ChannelBufferSize, prefix, commonConfig)
+		finish(clientConn, err)
+	}()
+
+	wg.Wait()
+	closeBoth()
+	return sent, received
+}
+
+// copyConn copies src to dst until EOF or an error and returns the number of
+// bytes copied. A clean EOF is reported as a nil error. Errors are logged in
+// debug mode, and otherwise with logTransferErrors unless they only come from
+// the connection being closed on purpose, after the client's log prefix.
+//
+// With a bufferSize, data moves in chunks of up to that size. The conns are
+// hidden behind plain Reader and Writer so that their ReadFrom and WriteTo
+// methods, which pick their own buffer, are not used.
+func copyConn(dst io.Writer, src io.Reader, bufferSize int, prefix string, commonConfig *CommonConfig) (int64, error) {
+	var n int64
+	var err error
+	if bufferSize > 0 {
+		n, err = io.CopyBuffer(struct{ io.Writer }{dst}, struct{ io.Reader }{src}, make([]byte, bufferSize))
+	} else {
+		n, err = io.Copy(dst, src)
+	}
+	if err != nil && err != io.EOF {
+		if commonConfig.Debug {
+			log.Printf(prefix+"Data transfer error: %v", err)
+		} else if commonConfig.LogTransferErrors && !isClosedConnError(err) {
+			log.Printf(prefix+"Warning: data transfer error after %d bytes: %v", n, err)
+		}
+	}
+	return n, err
+}
+
+// isClosedConnError reports whether err only says the connection was closed
+// locally, as happens when relay tears down the other direction.
+func isClosedConnError(err error) bool {
+	return errors.Is(err, net.ErrClosed) || errors.Is(err, io.ErrClosedPipe) || errors.Is(err, io.EOF)
+}
+
+// logSocks5Access writes the access log summary of a finished SOCKS5
+// connection. Sent bytes flow from the client to the target.
+func logSocks5Access(config *ForwardConfig, clientConn net.Conn, target string, start time.Time, sent, received int64) {
+	connLogf(clientConn, "SOCKS5 access forward=%s client=%s target=%s sent=%d received=%d duration=%s%s",
+		config.SectionName, clientConn.RemoteAddr(), target, sent, received, time.Since(start).Round(time.Millisecond), formatLabels(config.Labels))
+}
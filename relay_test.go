@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"testing"
+)
+
+// BenchmarkRelay measures relay throughput between loopback TCP connections
+// with the default copy (0) and several channelBufferSize values.
+func BenchmarkRelay(b *testing.B) {
+	for _, size := range []int{0, minChannelBufferSize, 32 << 10, 256 << 10, 1 << 20} {
+		name := "default"
+		if size > 0 {
+			name = fmt.Sprintf("%dKB", size>>10)
+		}
+		b.Run(name, func(b *testing.B) {
+			client, relayClient := dialLoopback(b)
+			relayTarget, target := dialLoopback(b)
+			config := &ForwardConfig{SectionName: "bench", ChannelBufferSize: size}
+			done := make(chan struct{})
+			go func() {
+				relay(relayClient, relayTarget, config, &CommonConfig{})
+				close(done)
+			}()
+
+			chunk := make([]byte, 1<<20)
+			b.SetBytes(int64(len(chunk)))
+			b.ResetTimer()
+			go func() {
+				for i := 0; i < b.N; i++ {
+					if _, err := client.Write(chunk); err != nil {
+						return
+					}
+				}
+			}()
+			if _, err := io.CopyN(io.Discard, target, int64(b.N)*int64(len(chunk))); err != nil {
+				b.Fatal(err)
+			}
+			b.StopTimer()
+
+			client.Close()
+			target.Close()
+			<-done
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"log"
+)
+
+// relinkServers points each forward at the first of its servers in the
+// config's server map, after reused servers were put into it.
+func relinkServers(config *Config) {
+	for _, fc := range config.Forwards {
+		fc.SSHConfig = nil
+		for _, name := range fc.ServerNames {
+			if serverConfig, ok := config.Servers[name]; ok {
+				fc.SSHConfig = serverConfig
+				break
+			}
+		}
+	}
+}
+
+// runsInBackground reports whether the forward is started with the others.
+// Forwards without a server are skipped, and stdio forwards only run in the
+// foreground with -stdio.
+func (fc *ForwardConfig) runsInBackground() bool {
+	return fc.SSHConfig != nil && fc.Direction != "stdio"
+}
+
+// usesServer reports whether a forward tunnels through one of the servers.
+func usesServer(config *ForwardConfig, serverNames map[string]bool) bool {
+	for _, name := range config.ServerNames {
+		if serverNames[name] {
+			return true
+		}
+	}
+	return config.EgressServer != "" && serverNames[config.EgressServer]
+}
+
+// closeServer closes every pooled connection of a server, so the next
+// GetConnection dials it with its new settings.
+func (cm *ConnectionManager) closeServer(serverName string) {
+	cm.mutex.Lock()
+	defer cm.mutex.Unlock()
+	for key, conn := range cm.connections {
+		if poolServerName(key) != serverName || conn == nil {
+			continue
+		}
+		cm.markLost(conn)
+		delete(cm.connections, key)
+		cm.setState(key, stateReconnecting)
+		log.Printf("Closed SSH connection for server: %s", key)
+		emitEvent(Event{Type: "disconnect", Server: key})
+	}
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Retries for a refused remote bind, with the delay doubling from 1s
+const remoteListenRetries = 5
+
+// listenRemote asks the SSH server to listen on host:port. When the server
+// refuses the bind, a forwarding left over from a previous connection may still
+// hold the port, so a cancel-tcpip-forward is sent for it and the bind is
+// retried with backoff until the server releases the old binding.
+func listenRemote(ctx context.Context, conn *ssh.Client, host, port string) (net.Listener, error) {
+	address := net.JoinHostPort(host, port)
+
+	listener, err := conn.Listen("tcp", address)
+	delay := time.Second
+	for attempt := 1; err != nil && isRemoteBindDenied(err) && attempt <= remoteListenRetries; attempt++ {
+		log.Printf("Remote bind on %s refused, releasing stale forwarding and retrying in %v (attempt %d/%d)",
+			address, delay, attempt, remoteListenRetries)
+		cancelRemoteForward(conn, host, port)
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+
+		listener, err = conn.Listen("tcp", address)
+		delay *= 2
+	}
+
+	if !isLoopbackHost(host) {
+		if err != nil && isRemoteBindDenied(err) {
+			return nil, fmt.Errorf("%v (binding %s on the server needs GatewayPorts yes or clientspecified in its sshd_config, and AllowTcpForwarding enabled)", err, host)
+		}
+		if err == nil {
+			log.Printf("Note: remote listener on %s is only reachable from other hosts when the server's sshd has GatewayPorts yes or clientspecified; with the default GatewayPorts no it stays on loopback", address)
+		}
+	}
+	return listener, err
+}
+
+// Most ports a remote forward's remotePort list may expand to
+const maxRemotePorts = 1024
+
+// parseRemotePorts expands a remotePort list of ports and ranges, e.g.
+// 8080,8090-8095, of a remote forward into RemotePorts. A single port is left
+// to normalizeForwardPorts.
+func parseRemotePorts(fc *ForwardConfig) error {
+	if fc.RemotePort == "" {
+		return nil
+	}
+	if _, err := normalizePort(fc.RemotePort); err == nil {
+		return nil
+	}
+	if !strings.ContainsAny(fc.RemotePort, ",-") {
+		return nil
+	}
+	if fc.Direction != "remote" {
+		return fmt.Errorf("a list or range of remotePort values only applies to remote forwards, not %s", fc.SectionName)
+	}
+
+	var ports []string
+	seen := make(map[string]bool)
+	for _, item := range strings.Split(fc.RemotePort, ",") {
+		item = strings.TrimSpace(item)
+		first, last := item, item
+		if from, to, ok := strings.Cut(item, "-"); ok {
+			first, last = strings.TrimSpace(from), strings.TrimSpace(to)
+		}
+		firstPort, err1 := strconv.Atoi(first)
+		lastPort, err2 := strconv.Atoi(last)
+		if err1 != nil || err2 != nil || firstPort < 1 || lastPort > 65535 || firstPort > lastPort {
+			return fmt.Errorf("invalid remotePort %q for %s (expected ports and ranges, e.g. 8080,8090-8095)", item, fc.SectionName)
+		}
+		for port := firstPort; port <= lastPort; port++ {
+			if seen[strconv.Itoa(port)] {
+				continue
+			}
+			if len(ports) == maxRemotePorts {
+				return fmt.Errorf("remotePort for %s lists more than %d ports", fc.SectionName, maxRemotePorts)
+			}
+			seen[strconv.Itoa(port)] = true
+			ports = append(ports, strconv.Itoa(port))
+		}
+	}
+	fc.RemotePorts = ports
+	return nil
+}
+
+// listenRemotePorts binds the remote listener of a remote forward: its
+// remotePort, or each port of its RemotePorts list. The ports are bound
+// concurrently and the listener is returned as soon as one is, so a port
+// retrying a refused bind does not hold up the others; those bound later join
+// it. Ports that cannot be bound are logged and skipped, and it only fails when
+// none can be.
+func listenRemotePorts(ctx context.Context, conn *ssh.Client, config *ForwardConfig) (net.Listener, error) {
+	if len(config.RemotePorts) == 0 {
+		return listenRemote(ctx, conn, config.RemoteIP, config.RemotePort)
+	}
+
+	multi := newMultiListener()
+	var returned atomic.Bool
+	results := make(chan error, len(config.RemotePorts))
+	for _, port := range config.RemotePorts {
+		go func(port string) {
+			listener, err := listenRemote(ctx, conn, config.RemoteIP, port)
+			switch {
+			case err == nil && multi.add(listener):
+				config.setListenAddr(multi.Addr().String())
+				if returned.Load() {
+					log.Printf("Also listening on %s for remote port forwarding", listener.Addr())
+				}
+			case err == nil:
+				listener.Close()
+			case ctx.Err() == nil:
+				log.Printf("Remote port %s of %s not bound, continuing without it: %v", port, config.SectionName, err)
+			}
+			results <- err
+		}(port)
+	}
+
+	var err error
+	for range config.RemotePorts {
+		if err = <-results; err == nil {
+			returned.Store(true)
+			return multi, nil
+		}
+	}
+	multi.Close()
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	return nil, fmt.Errorf("none of the %d ports of remotePort could be bound: %v", len(config.RemotePorts), err)
+}
+
+// multiListener accepts connections from several listeners as one. The first
+// Accept error of any of them is returned, so a forward whose SSH connection
+// dies restarts as it would with a single listener.
+type multiListener struct {
+	listeners []net.Listener
+	closed    bool
+	mutex     sync.Mutex
+	accepted  chan acceptResult
+	done      chan struct{}
+}
+
+type acceptResult struct {
+	conn net.Conn
+	err  error
+}
+
+func newMultiListener() *multiListener {
+	return &multiListener{
+		accepted: make(chan acceptResult),
+		done:     make(chan struct{}),
+	}
+}
+
+// add starts accepting from listener, reporting false if m is already closed
+func (m *multiListener) add(listener net.Listener) bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.closed {
+		return false
+	}
+	m.listeners = append(m.listeners, listener)
+	go m.acceptLoop(listener)
+	return true
+}
+
+func (m *multiListener) acceptLoop(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		select {
+		case m.accepted <- acceptResult{conn, err}:
+		case <-m.done:
+			if conn != nil {
+				conn.Close()
+			}
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (m *multiListener) Accept() (net.Conn, error) {
+	select {
+	case result := <-m.accepted:
+		return result.conn, result.err
+	case <-m.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (m *multiListener) Close() error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if !m.closed {
+		m.closed = true
+		close(m.done)
+		for _, listener := range m.listeners {
+			listener.Close()
+		}
+	}
+	return nil
+}
+
+// Addr lists the addresses of the listeners bound so far
+func (m *multiListener) Addr() net.Addr {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	addrs := make(multiAddr, len(m.listeners))
+	for i, listener := range m.listeners {
+		addrs[i] = listener.Addr()
+	}
+	return addrs
+}
+
+type multiAddr []net.Addr
+
+func (a multiAddr) Network() string { return "tcp" }
+
+func (a multiAddr) String() string {
+	addrs := make([]string, len(a))
+	for i, addr := range a {
+		addrs[i] = addr.String()
+	}
+	return strings.Join(addrs, ", ")
+}
+
+// isLoopbackHost reports whether a remote bind address only accepts local
+// connections on the server, which sshd allows regardless of GatewayPorts.
+func isLoopbackHost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// isRemoteBindDenied reports whether a Listen error is the server refusing the
+// tcpip-forward request, which is how "address already in use" surfaces.
+func isRemoteBindDenied(err error) bool {
+	return strings.Contains(err.Error(), "tcpip-forward request denied")
+}
+
+// cancelRemoteForward sends a cancel-tcpip-forward global request for
+// host:port. Servers only honour it for forwardings owned by this connection,
+// so failures are expected and ignored.
+func cancelRemoteForward(conn *ssh.Client, host, port string) {
+	portNum, err := strconv.Atoi(port)
+	if err != nil {
+		return
+	}
+
+	payload := ssh.Marshal(&struct {
+		Addr string
+		Port uint32
+	}{host, uint32(portNum)})
+	conn.SendRequest("cancel-tcpip-forward", true, payload)
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/ini.v1"
+)
+
+// Time a fetch of configURL may take
+const remoteConfigTimeout = 30 * time.Second
+
+// Largest configuration document accepted from configURL
+const maxRemoteConfigSize = 10 << 20
+
+// Environment variable holding the configURL bearer token by default
+const defaultConfigTokenEnv = "SPF_CONFIG_TOKEN"
+
+// remoteConfigDocument is the JSON served at configURL: sections by name,
+// each a map of the keys a config.ini section would have. Values may be
+// strings, numbers, booleans or lists, which are joined with commas.
+type remoteConfigDocument struct {
+	Servers  map[string]map[string]interface{} `json:"servers"`
+	Forwards map[string]map[string]interface{} `json:"forwards"`
+}
+
+// The last document fetched and parsed successfully, used when a later fetch
+// fails
+var lastRemoteConfig struct {
+	mutex sync.Mutex
+	url   string
+	body  []byte
+}
+
+// fetchRemoteConfig downloads and parses the document at url, sending the
+// bearer token from the tokenEnv environment variable when it is set.
+func fetchRemoteConfig(url, tokenEnv string) ([]byte, *remoteConfigDocument, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), remoteConfigTimeout)
+	defer cancel()
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	if token := os.Getenv(tokenEnv); token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+	request.Header.Set("Accept", "application/json")
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("unexpected status %s", response.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(response.Body, maxRemoteConfigSize+1))
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(body) > maxRemoteConfigSize {
+		return nil, nil, fmt.Errorf("document larger than %d bytes", maxRemoteConfigSize)
+	}
+	doc, err := parseRemoteConfig(body)
+	if err != nil {
+		return nil, nil, err
+	}
+	return body, doc, nil
+}
+
+func parseRemoteConfig(body []byte) (*remoteConfigDocument, error) {
+	doc := &remoteConfigDocument{}
+	if err := json.Unmarshal(body, doc); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %v", err)
+	}
+	return doc, nil
+}
+
+// mergeRemoteConfig adds the sections served at [common] configURL to cfg.
+// Sections of the local file win over remote ones of the same name. When the
+// fetch fails, the last document fetched since startup is used instead.
+func mergeRemoteConfig(cfg *ini.File) error {
+	if !cfg.HasSection("common") {
+		return nil
+	}
+	common := cfg.Section("common")
+	url := common.Key("configURL").String()
+	if url == "" {
+		return nil
+	}
+
+	body, doc, err := fetchRemoteConfig(url, common.Key("configTokenEnv").MustString(defaultConfigTokenEnv))
+	lastRemoteConfig.mutex.Lock()
+	if err == nil {
+		lastRemoteConfig.url, lastRemoteConfig.body = url, body
+	} else if lastRemoteConfig.url == url && lastRemoteConfig.body != nil {
+		log.Printf("Warning: failed to fetch configuration from %s, using the last fetched one: %v", url, err)
+		doc, err = parseRemoteConfig(lastRemoteConfig.body)
+	}
+	lastRemoteConfig.mutex.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to fetch configuration from %s: %v", url, err)
+	}
+
+	merged := 0
+	for _, sections := range []map[string]map[string]interface{}{doc.Servers, doc.Forwards} {
+		var names []string
+		for name := range sections {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			if name == "common" || name == ini.DefaultSection {
+				return fmt.Errorf("configuration from %s may not define section %s", url, name)
+			}
+			if _, err := cfg.GetSection(name); err == nil {
+				log.Printf("Warning: section %s from %s is also in the local configuration, using the local one", name, url)
+				continue
+			}
+			section, err := cfg.NewSection(name)
+			if err != nil {
+				return fmt.Errorf("invalid section %q from %s: %v", name, url, err)
+			}
+			for key, value := range sections[name] {
+				text, err := remoteConfigValue(value)
+				if err != nil {
+					return fmt.Errorf("invalid %s in section %s from %s: %v", key, name, url, err)
+				}
+				if _, err := section.NewKey(key, text); err != nil {
+					return fmt.Errorf("invalid key %q in section %s from %s: %v", key, name, url, err)
+				}
+			}
+			merged++
+		}
+	}
+	log.Printf("Merged %d sections from %s", merged, url)
+	return nil
+}
+
+// remoteConfigValue converts a JSON value to its config.ini form.
+func remoteConfigValue(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case []interface{}:
+		items := make([]string, len(v))
+		for i, item := range v {
+			text, err := remoteConfigValue(item)
+			if err != nil {
+				return "", err
+			}
+			items[i] = text
+		}
+		return strings.Join(items, ","), nil
+	default:
+		return "", fmt.Errorf("unsupported value %v", value)
+	}
+}
+
+// pollRemoteConfig fetches configURL every interval until ctx is done and
+// calls reload when the document differs from the last one fetched. Failed
+// fetches are logged and leave the configuration as it is.
+func pollRemoteConfig(ctx context.Context, url, tokenEnv string, interval time.Duration, reload func()) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+
+		body, _, err := fetchRemoteConfig(url, tokenEnv)
+		if err != nil {
+			log.Printf("Warning: failed to poll configuration from %s: %v", url, err)
+			continue
+		}
+		lastRemoteConfig.mutex.Lock()
+		changed := !bytes.Equal(body, lastRemoteConfig.body)
+		lastRemoteConfig.mutex.Unlock()
+		if changed {
+			log.Printf("Configuration at %s changed, reloading...", url)
+			reload()
+		}
+	}
+}
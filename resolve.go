@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// DNS resolution modes for SOCKS5 domain targets
+const (
+	resolveLocal  = "local"  // resolve on this machine
+	resolveRemote = "remote" // resolve on the SSH server side, through the tunnel
+)
+
+const resolveTimeout = 10 * time.Second
+
+// tunnelResolver returns a resolver that sends DNS queries over TCP to
+// dnsServer through the SSH connection, so lookups do not leak locally.
+func tunnelResolver(sshConn *ssh.Client, dnsServer string) *net.Resolver {
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			return sshConn.Dial("tcp", dnsServer)
+		},
+	}
+}
+
+// resolveHost looks up host with the given resolver and returns its first
+// address, limited to family when it is set.
+func resolveHost(resolver *net.Resolver, host, family string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), resolveTimeout)
+	defer cancel()
+
+	ips, err := resolver.LookupIP(ctx, familyNetwork("ip", family), host)
+	if err != nil {
+		return "", err
+	}
+	if len(ips) == 0 {
+		return "", fmt.Errorf("no addresses found for %s", host)
+	}
+	return ips[0].String(), nil
+}
+
+// Tor's SOCKS5 RESOLVE extension command, which looks up a name without
+// connecting anywhere
+const socks5CommandResolve = 0xF0
+
+// handleSocks5Resolve answers a RESOLVE request for host with its address in
+// the reply's bound address field, looked up with resolver. requested is the
+// host:port as sent by the client, checked against denyTargets with the
+// result.
+func handleSocks5Resolve(clientConn net.Conn, config *ForwardConfig, resolver *net.Resolver, host, requested string, commonConfig *CommonConfig) error {
+	resolved := host
+	if net.ParseIP(host) == nil {
+		var err error
+		if resolved, err = resolveHost(resolver, host, config.IPFamily); err != nil {
+			clientConn.Write(socks5Reply(0x04, nil))
+			return fmt.Errorf("failed to resolve %s: %v", host, err)
+		}
+	}
+
+	_, port, _ := net.SplitHostPort(requested)
+	if !checkTarget(config, clientConn.RemoteAddr(), "RESOLVE", requested, net.JoinHostPort(resolved, port)) {
+		clientConn.Write(socks5Reply(0x02, nil))
+		return fmt.Errorf("target %s not allowed", requested)
+	}
+
+	if _, err := clientConn.Write(socks5Reply(0x00, &net.TCPAddr{IP: net.ParseIP(resolved)})); err != nil {
+		return fmt.Errorf("failed to send resolve response: %v", err)
+	}
+	if commonConfig.Debug {
+		log.Printf("SOCKS5 RESOLVE of %s answered with %s", host, resolved)
+	}
+	return nil
+}
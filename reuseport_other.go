@@ -0,0 +1,17 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"syscall"
+)
+
+// Whether the reusePort option has an effect on this platform
+const reusePortSupported = false
+
+// reusePortControl leaves sockets unchanged; reusePort is only supported on
+// Linux.
+func reusePortControl(network, address string, c syscall.RawConn) error {
+	return nil
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// Furthest ahead a schedule is searched for its next change
+const scheduleHorizon = 8 * 24 * time.Hour
+
+var weekdayNames = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// scheduleWindow is a daily time range on some weekdays. A range ending
+// before it starts runs past midnight into the next day.
+type scheduleWindow struct {
+	days       [7]bool
+	start, end int // minutes since midnight
+}
+
+// schedule is the set of windows in which a forward runs
+type schedule struct {
+	windows []scheduleWindow
+}
+
+// parseSchedule parses windows separated by ";", each an optional list of
+// days followed by a time range, e.g. "Mon-Fri 09:00-18:00; Sat 10:00-14:00".
+// Days are names or ranges separated by commas; without days the window
+// applies every day.
+func parseSchedule(value string) (*schedule, error) {
+	var s schedule
+	for _, part := range strings.Split(value, ";") {
+		fields := strings.Fields(part)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) > 2 {
+			return nil, fmt.Errorf("invalid window %q", strings.TrimSpace(part))
+		}
+
+		var window scheduleWindow
+		if len(fields) == 1 {
+			window.days = [7]bool{true, true, true, true, true, true, true}
+		} else if err := parseScheduleDays(fields[0], &window.days); err != nil {
+			return nil, err
+		}
+
+		timeRange := fields[len(fields)-1]
+		from, to, ok := strings.Cut(timeRange, "-")
+		if !ok {
+			return nil, fmt.Errorf("invalid time range %q (expected HH:MM-HH:MM)", timeRange)
+		}
+		var err error
+		if window.start, err = parseClock(from); err != nil {
+			return nil, err
+		}
+		if window.end, err = parseClock(to); err != nil {
+			return nil, err
+		}
+		if window.start == window.end {
+			return nil, fmt.Errorf("empty time range %q", timeRange)
+		}
+		s.windows = append(s.windows, window)
+	}
+	if len(s.windows) == 0 {
+		return nil, fmt.Errorf("no windows in %q", value)
+	}
+	return &s, nil
+}
+
+// parseScheduleDays marks the days of a list such as "Mon-Fri" or "Mon,Wed".
+func parseScheduleDays(value string, days *[7]bool) error {
+	for _, item := range strings.Split(value, ",") {
+		from, to, isRange := strings.Cut(item, "-")
+		first, ok := weekdayNames[strings.ToLower(from)]
+		if !ok {
+			return fmt.Errorf("invalid day %q", from)
+		}
+		last := first
+		if isRange {
+			if last, ok = weekdayNames[strings.ToLower(to)]; !ok {
+				return fmt.Errorf("invalid day %q", to)
+			}
+		}
+		// Ranges may wrap around the week, e.g. Sat-Sun
+		for day := first; ; day = (day + 1) % 7 {
+			days[day] = true
+			if day == last {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// parseClock parses HH:MM into minutes since midnight; 24:00 ends a day.
+func parseClock(value string) (int, error) {
+	var hour, minute int
+	if _, err := fmt.Sscanf(value, "%d:%d", &hour, &minute); err != nil || minute < 0 || minute > 59 || hour < 0 || hour > 24 || (hour == 24 && minute != 0) {
+		return 0, fmt.Errorf("invalid time %q (expected HH:MM)", value)
+	}
+	return hour*60 + minute, nil
+}
+
+// active reports whether t falls in one of the windows.
+func (s *schedule) active(t time.Time) bool {
+	minute := t.Hour()*60 + t.Minute()
+	today := t.Weekday()
+	yesterday := (today + 6) % 7
+	for _, w := range s.windows {
+		if w.start < w.end {
+			if w.days[today] && minute >= w.start && minute < w.end {
+				return true
+			}
+			continue
+		}
+		// Overnight: the evening of a listed day or the morning after it
+		if (w.days[today] && minute >= w.start) || (w.days[yesterday] && minute < w.end) {
+			return true
+		}
+	}
+	return false
+}
+
+// nextChange returns the start of the first minute after t in which active
+// differs from active(t), or the zero time if there is none in the horizon.
+func (s *schedule) nextChange(t time.Time) time.Time {
+	current := s.active(t)
+	next := t.Truncate(time.Minute)
+	for limit := t.Add(scheduleHorizon); next.Before(limit); {
+		next = next.Add(time.Minute)
+		if s.active(next) != current {
+			return next
+		}
+	}
+	return time.Time{}
+}
+
+// runScheduled calls run with a context that is only live while the
+// forward's schedule is active, so the forward's listener is closed and no
+// channels are opened outside its windows. Without a schedule run simply
+// gets ctx.
+func runScheduled(ctx context.Context, config *ForwardConfig, commonConfig *CommonConfig, run func(context.Context, *ForwardConfig, *CommonConfig)) {
+	if config.Schedule == nil {
+		run(ctx, config, commonConfig)
+		return
+	}
+
+	for ctx.Err() == nil {
+		now := time.Now().In(commonConfig.Location)
+		change := config.Schedule.nextChange(now)
+
+		if config.Schedule.active(now) {
+			if change.IsZero() {
+				log.Printf("Schedule of %s is always active", config.SectionName)
+				run(ctx, config, commonConfig)
+				return
+			}
+			log.Printf("Starting %s for its schedule until %s", config.SectionName, change.Format("Mon 15:04"))
+			windowCtx, cancel := context.WithDeadline(ctx, change)
+			run(windowCtx, config, commonConfig)
+			cancel()
+			if ctx.Err() == nil {
+				log.Printf("Stopped %s outside its schedule", config.SectionName)
+			}
+			continue
+		}
+
+		if change.IsZero() {
+			log.Printf("Schedule of %s is never active, not starting it", config.SectionName)
+			return
+		}
+		log.Printf("%s is outside its schedule, starting at %s", config.SectionName, change.Format("Mon 15:04"))
+		select {
+		case <-time.After(time.Until(change)):
+		case <-ctx.Done():
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"reflect"
+	"sync"
+	"sync/atomic"
+)
+
+// The servers of the loaded configuration, by section name. A published map
+// and the ServerConfigs in it are never modified: a reload publishes a new
+// map, carrying the runtime state of unchanged servers over, so goroutines
+// reading it during a reload only need serversMutex to fetch it.
+var (
+	servers      map[string]*ServerConfig
+	serversMutex sync.RWMutex
+)
+
+// lookupServer returns the configuration of the named server, or nil.
+func lookupServer(name string) *ServerConfig {
+	serversMutex.RLock()
+	defer serversMutex.RUnlock()
+	return servers[name]
+}
+
+// loadedServers returns the current servers map, which must not be modified.
+func loadedServers() map[string]*ServerConfig {
+	serversMutex.RLock()
+	defer serversMutex.RUnlock()
+	return servers
+}
+
+// publishServers makes a newly loaded servers map the current one.
+func publishServers(loaded map[string]*ServerConfig) {
+	serversMutex.Lock()
+	defer serversMutex.Unlock()
+	servers = loaded
+}
+
+// carryServerState moves the runtime state of a server kept across a reload
+// onto its newly loaded config: the channel slots in use, whether the remote
+// user has been verified and the pool's round-robin position. A changed
+// maxChannels starts with fresh slots, counting only channels opened after
+// the reload.
+func carryServerState(sc, old *ServerConfig) {
+	if cap(sc.channelSlots) == cap(old.channelSlots) {
+		sc.channelSlots = old.channelSlots
+	}
+	sc.verifyOnce = old.verifyOnce
+	atomic.StoreUint32(&sc.poolNext, atomic.LoadUint32(&old.poolNext))
+}
+
+// connectionSettingsChanged reports whether a reloaded server differs from
+// its previous config in how it is dialed or authenticated, which needs a new
+// connection. Other settings, such as keepalives, maxChannels or
+// maxConnectionLifetime, are looked up when used and apply to the existing
+// connection.
+func connectionSettingsChanged(sc, old *ServerConfig) bool {
+	return sc.Server != old.Server || sc.Port != old.Port || sc.User != old.User ||
+		sc.Password != old.Password || sc.IdentityFile != old.IdentityFile ||
+		!reflect.DeepEqual(sc.AuthOrder, old.AuthOrder) ||
+		!reflect.DeepEqual(sc.Ciphers, old.Ciphers) ||
+		!reflect.DeepEqual(sc.KeyExchanges, old.KeyExchanges) ||
+		!reflect.DeepEqual(sc.MACs, old.MACs) ||
+		!reflect.DeepEqual(sc.ProxyJump, old.ProxyJump) ||
+		sc.PreferAddressFamily != old.PreferAddressFamily || sc.IPFamily != old.IPFamily ||
+		sc.wsURL != old.wsURL || sc.PoolSize != old.PoolSize ||
+		sc.RekeyThreshold != old.RekeyThreshold || sc.Monitor != old.Monitor
+}
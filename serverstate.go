@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Connection states of a server, as reported by /status, the tray menu and
+// state events
+const (
+	stateConnecting   = "connecting"   // first dial, or not dialed yet
+	stateConnected    = "connected"    // keepalives answered
+	stateDegraded     = "degraded"     // keepalives going unanswered, not yet given up
+	stateReconnecting = "reconnecting" // connection lost, forwards dialing again
+	stateFailed       = "failed"       // the latest dial failed
+)
+
+// Every state, in the order exported by /metrics
+var serverStates = []string{stateConnecting, stateConnected, stateDegraded, stateReconnecting, stateFailed}
+
+// How long a keepalive may go unanswered before it counts as missed
+const keepaliveTimeout = 15 * time.Second
+
+// Default number of consecutive missed keepalives before the connection is
+// closed
+const defaultKeepaliveFailures = 3
+
+// Default global request sent as keepalive, and the keepaliveRequest value
+// that opens a session channel instead
+const (
+	defaultKeepaliveRequest = "keepalive@openssh.com"
+	keepaliveSession        = "session"
+)
+
+var errKeepaliveTimeout = fmt.Errorf("keepalive not answered within %s", keepaliveTimeout)
+
+// sendKeepalive pings the server with its keepaliveRequest, giving up after
+// keepaliveTimeout. A reply arriving later is discarded. Any answer, even a
+// refusal from a server that does not know the request, shows the
+// connection is alive; only transport errors are returned.
+func sendKeepalive(serverName string, conn *ssh.Client) error {
+	request := defaultKeepaliveRequest
+	if serverConfig := lookupServer(poolServerName(serverName)); serverConfig != nil {
+		request = serverConfig.KeepaliveRequest
+	}
+	result := make(chan error, 1)
+	go func() {
+		if request != keepaliveSession {
+			_, _, err := conn.SendRequest(request, true, nil)
+			result <- err
+			return
+		}
+		session, err := conn.NewSession()
+		if err == nil {
+			session.Close()
+		}
+		if _, refused := err.(*ssh.OpenChannelError); refused {
+			err = nil
+		}
+		result <- err
+	}()
+	select {
+	case err := <-result:
+		return err
+	case <-time.After(keepaliveTimeout):
+		return errKeepaliveTimeout
+	}
+}
+
+// tolerateMissedKeepalive reports whether the connection is kept after missed
+// consecutive unanswered keepalives, marking the server degraded if so.
+func (cm *ConnectionManager) tolerateMissedKeepalive(serverName string, missed int) bool {
+	limit := defaultKeepaliveFailures
+	if serverConfig := lookupServer(poolServerName(serverName)); serverConfig != nil {
+		limit = serverConfig.KeepaliveFailures
+	}
+	if missed >= limit {
+		return false
+	}
+	log.Printf("SSH keepalive for server %s not answered (%d missed, closing after %d), connection degraded", serverName, missed, limit)
+	cm.mutex.Lock()
+	cm.setState(serverName, stateDegraded)
+	cm.mutex.Unlock()
+	return true
+}
+
+// statsFor returns the server's connection history, creating it on first
+// use. Callers hold cm.mutex.
+func (cm *ConnectionManager) statsFor(serverName string) *connectionStats {
+	stats, ok := cm.stats[serverName]
+	if !ok {
+		stats = &connectionStats{}
+		cm.stats[serverName] = stats
+	}
+	return stats
+}
+
+// setState records the server's connection state and emits a state event
+// when it changes. Callers hold cm.mutex.
+func (cm *ConnectionManager) setState(serverName, state string) {
+	stats := cm.statsFor(serverName)
+	if stats.state == state {
+		return
+	}
+	stats.state = state
+	emitEvent(Event{Type: "state", Server: serverName, State: state})
+}
+
+// beginDial marks the server as connecting, or reconnecting when it has been
+// connected before. Callers hold cm.mutex.
+func (cm *ConnectionManager) beginDial(serverName string) {
+	if cm.statsFor(serverName).connects > 0 {
+		cm.setState(serverName, stateReconnecting)
+	} else {
+		cm.setState(serverName, stateConnecting)
+	}
+}
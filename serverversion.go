@@ -0,0 +1,72 @@
+package main
+
+import (
+	"log"
+	"strconv"
+	"strings"
+)
+
+// Server software with known compatibility problems, reported when
+// warnOldServers is set. Versions are compared numerically, below is the
+// first release without the problem.
+var oldServerVersions = []struct {
+	software string
+	below    []int
+	problem  string
+}{
+	{"OpenSSH_", []int{7, 2}, "no rsa-sha2 signatures, so RSA keys fall back to SHA-1 ssh-rsa that newer servers and clients refuse"},
+	{"dropbear_", []int{2020, 79}, "no rsa-sha2 signatures, so RSA keys fall back to SHA-1 ssh-rsa that newer servers and clients refuse"},
+}
+
+// warnOldServer logs a warning when version, the server's identification
+// string such as SSH-2.0-OpenSSH_9.6p1, names server software with a known
+// problem.
+func warnOldServer(serverName, version string) {
+	// The software version follows the protocol version, optionally followed
+	// by a space and comments
+	software := strings.TrimPrefix(strings.TrimPrefix(version, "SSH-2.0-"), "SSH-1.99-")
+	software, _, _ = strings.Cut(software, " ")
+	for _, old := range oldServerVersions {
+		if !strings.HasPrefix(software, old.software) {
+			continue
+		}
+		if parsed, ok := parseVersionNumbers(strings.TrimPrefix(software, old.software)); ok && versionBelow(parsed, old.below) {
+			log.Printf("Warning: server %s runs %s: %s", serverName, software, old.problem)
+		}
+	}
+}
+
+// parseVersionNumbers parses the leading dotted numbers of a version such as
+// 7.1p2 into [7 1].
+func parseVersionNumbers(version string) ([]int, bool) {
+	var numbers []int
+	for _, part := range strings.Split(version, ".") {
+		end := 0
+		for end < len(part) && part[end] >= '0' && part[end] <= '9' {
+			end++
+		}
+		number, err := strconv.Atoi(part[:end])
+		if err != nil {
+			break
+		}
+		numbers = append(numbers, number)
+		if end < len(part) {
+			break
+		}
+	}
+	return numbers, len(numbers) > 0
+}
+
+// versionBelow reports whether version is lower than limit.
+func versionBelow(version, limit []int) bool {
+	for i, want := range limit {
+		have := 0
+		if i < len(version) {
+			have = version[i]
+		}
+		if have != want {
+			return have < want
+		}
+	}
+	return false
+}
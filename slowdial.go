@@ -0,0 +1,19 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+// warnSlowDial logs a warning when a dial that succeeded took longer than
+// threshold, so a server or target getting slower shows up before its dials
+// start failing. A zero threshold disables the warning.
+func warnSlowDial(what string, start time.Time, threshold time.Duration) {
+	if threshold <= 0 {
+		return
+	}
+	if elapsed := time.Since(start); elapsed > threshold {
+		log.Printf("Warning: slow dial to %s took %s (slowDialThreshold %s)",
+			what, elapsed.Round(time.Millisecond), threshold)
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Time a client has to send its TLS ClientHello
+const sniReadTimeout = 10 * time.Second
+
+// Largest TLS record: 5-byte header plus up to 16 KiB and expansion
+const tlsMaxRecord = 5 + 16384 + 2048
+
+// sniRoute maps a TLS server name, or a *.domain wildcard, to the target
+// dialed through the tunnel
+type sniRoute struct {
+	Pattern string
+	Target  string
+}
+
+// parseSNIRoutes parses a comma-separated list of name=host[:port] routes.
+// The port defaults to 443.
+func parseSNIRoutes(value string) ([]sniRoute, error) {
+	var routes []sniRoute
+	for _, item := range splitList(value) {
+		name, target, ok := strings.Cut(item, "=")
+		name, target = strings.TrimSpace(name), strings.TrimSpace(target)
+		if !ok || name == "" || target == "" {
+			return nil, fmt.Errorf("invalid route %q (expected name=host:port)", item)
+		}
+		host, port, err := net.SplitHostPort(target)
+		if err != nil {
+			host, port = strings.Trim(target, "[]"), "443"
+		}
+		if port, err = normalizePort(port); err != nil {
+			return nil, fmt.Errorf("invalid route %q: %v", item, err)
+		}
+		routes = append(routes, sniRoute{Pattern: strings.ToLower(name), Target: net.JoinHostPort(host, port)})
+	}
+	return routes, nil
+}
+
+// sniTarget returns the target for serverName: an exact route first, then the
+// longest matching *.domain route, then remoteIP:remotePort if configured.
+func sniTarget(config *ForwardConfig, serverName string) (string, bool) {
+	serverName = strings.ToLower(serverName)
+	best, bestLen := "", -1
+	for _, route := range config.SNIRoutes {
+		if route.Pattern == serverName {
+			return route.Target, true
+		}
+		if suffix, ok := strings.CutPrefix(route.Pattern, "*"); ok && strings.HasSuffix(serverName, suffix) && len(suffix) > bestLen {
+			best, bestLen = route.Target, len(suffix)
+		}
+	}
+	if bestLen >= 0 {
+		return best, true
+	}
+	if config.RemoteIP != "" && config.RemotePort != "" {
+		return net.JoinHostPort(config.RemoteIP, config.RemotePort), true
+	}
+	return "", false
+}
+
+func handleSNIRoute(ctx context.Context, conn *ssh.Client, config *ForwardConfig, commonConfig *CommonConfig) error {
+	listener, err := listenLocal(config)
+	if err != nil {
+		return fmt.Errorf("failed to listen on local address: %v", err)
+	}
+	listener = wrapListenerDSCP(wrapListenerRate(wrapListenerSource(listener, config), config), config)
+	defer listener.Close()
+
+	// Remember the bound address, which has the chosen port when 0 was configured
+	config.setListenAddr(listener.Addr().String())
+	defer config.setListenAddr("")
+
+	// Close the listener on shutdown or when the connection is lost
+	stop := context.AfterFunc(ctx, func() { listener.Close() })
+	defer stop()
+
+	log.Printf("Listening on %s for SNI routing", listener.Addr())
+
+	workers := startWorkerPool(ctx, config)
+	for {
+		clientConn, err := listener.Accept()
+		if err != nil {
+			// Listener closed by shutdown, reload or stop
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("failed to accept connection: %v", err)
+		}
+
+		// [common] as of this connection; a reload publishes a new one
+		commonConfig := currentCommon()
+		workers.run(ctx, clientConn, traceConnection(commonConfig, config, clientConn, func() {
+			if err := handleSNIConnection(ctx, clientConn, conn, config, commonConfig); err != nil {
+				connLogf(clientConn, "SNI route error on %s: %v", config.SectionName, err)
+			}
+		}))
+	}
+}
+
+// handleSNIConnection reads the client's ClientHello without consuming it,
+// dials the target routed for its server name, and relays the connection,
+// handshake included, so TLS is negotiated end to end.
+func handleSNIConnection(ctx context.Context, clientConn net.Conn, conn *ssh.Client, config *ForwardConfig, commonConfig *CommonConfig) error {
+	reader := bufio.NewReaderSize(clientConn, tlsMaxRecord)
+	clientConn.SetReadDeadline(time.Now().Add(sniReadTimeout))
+	serverName, err := peekServerName(reader)
+	clientConn.SetReadDeadline(time.Time{})
+	if err != nil {
+		clientConn.Close()
+		return fmt.Errorf("client %s: %v", clientConn.RemoteAddr(), err)
+	}
+
+	target, ok := sniTarget(config, serverName)
+	if !ok {
+		clientConn.Close()
+		return fmt.Errorf("no route for server name %q from %s", serverName, clientConn.RemoteAddr())
+	}
+	if commonConfig.Debug {
+		connLogf(clientConn, "SNI %q from %s routed to %s", serverName, clientConn.RemoteAddr(), target)
+	}
+
+	dialCtx, cancel := context.WithTimeout(ctx, targetDialTimeout(config))
+	targetConn, err := dialChannel(dialCtx, conn, config, target, commonConfig)
+	cancel()
+	if err != nil {
+		clientConn.Close()
+		return fmt.Errorf("failed to connect to %s for %q: %v", target, serverName, err)
+	}
+
+	start := time.Now()
+	sent, received := relay(&bufferedConn{Conn: clientConn, reader: reader}, targetConn, config, commonConfig)
+	emitTransfer(config, clientConn.RemoteAddr(), target, start, sent, received)
+	return nil
+}
+
+// errHelloRead stops the TLS handshake once the ClientHello has been seen
+var errHelloRead = errors.New("client hello read")
+
+// peekServerName returns the SNI server name of the ClientHello at the start
+// of reader, leaving the bytes buffered for replay. A ClientHello without SNI
+// gives an empty name.
+func peekServerName(reader *bufio.Reader) (string, error) {
+	header, err := reader.Peek(5)
+	if err != nil {
+		return "", fmt.Errorf("failed to read TLS record header: %v", err)
+	}
+	if header[0] != 0x16 { // handshake record
+		return "", fmt.Errorf("not a TLS handshake")
+	}
+	length := int(header[3])<<8 | int(header[4])
+	record, err := reader.Peek(5 + length)
+	if err != nil {
+		return "", fmt.Errorf("failed to read TLS ClientHello: %v", err)
+	}
+
+	// Let crypto/tls parse the hello on a copy and abort right after
+	var serverName string
+	var seen bool
+	tlsConn := tls.Server(&helloConn{reader: bytes.NewReader(record)}, &tls.Config{
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			serverName, seen = hello.ServerName, true
+			return nil, errHelloRead
+		},
+	})
+	tlsConn.Handshake()
+	if !seen {
+		return "", fmt.Errorf("invalid TLS ClientHello")
+	}
+	return serverName, nil
+}
+
+// helloConn feeds recorded bytes to crypto/tls and discards its replies.
+type helloConn struct {
+	net.Conn
+	reader io.Reader
+}
+
+func (c *helloConn) Read(p []byte) (int, error)         { return c.reader.Read(p) }
+func (c *helloConn) Write(p []byte) (int, error)        { return len(p), nil }
+func (c *helloConn) Close() error                       { return nil }
+func (c *helloConn) LocalAddr() net.Addr                { return nil }
+func (c *helloConn) RemoteAddr() net.Addr               { return nil }
+func (c *helloConn) SetDeadline(t time.Time) error      { return nil }
+func (c *helloConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *helloConn) SetWriteDeadline(t time.Time) error { return nil }
+
+// bufferedConn reads through reader, which holds bytes already peeked from
+// Conn.
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
+// CloseWrite keeps half-close working through the wrapper.
+func (c *bufferedConn) CloseWrite() error {
+	if halfCloser, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return halfCloser.CloseWrite()
+	}
+	return errors.New("half-close not supported")
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+)
+
+// SOCKS4 reply codes
+const (
+	socks4Granted  = 0x5A
+	socks4Rejected = 0x5B
+)
+
+// Longest SOCKS4 request accepted, including user ID and 4a domain name
+const socks4MaxRequest = 1024
+
+// socks4Request is a parsed SOCKS4 or SOCKS4a CONNECT request
+type socks4Request struct {
+	command byte
+	host    string
+	port    uint16
+	domain  bool // SOCKS4a: host is a domain name for the proxy to resolve
+}
+
+// readSocks4Request parses a SOCKS4/4a request whose first bytes have already
+// been read into initial, reading the rest from conn as needed.
+func readSocks4Request(conn net.Conn, initial []byte) (*socks4Request, error) {
+	buf := append([]byte(nil), initial...)
+
+	// VN, CD, DSTPORT, DSTIP, USERID, NUL [, DOMAIN, NUL for 4a]
+	complete := func() bool {
+		if len(buf) < 9 {
+			return false
+		}
+		userEnd := bytes.IndexByte(buf[8:], 0)
+		if userEnd < 0 {
+			return false
+		}
+		if isSocks4a(buf) {
+			return bytes.IndexByte(buf[8+userEnd+1:], 0) >= 0
+		}
+		return true
+	}
+
+	chunk := make([]byte, 256)
+	for !complete() {
+		if len(buf) > socks4MaxRequest {
+			return nil, fmt.Errorf("SOCKS4 request too long")
+		}
+		n, err := conn.Read(chunk)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read SOCKS4 request: %v", err)
+		}
+		buf = append(buf, chunk[:n]...)
+	}
+
+	req := &socks4Request{
+		command: buf[1],
+		port:    binary.BigEndian.Uint16(buf[2:4]),
+		host:    net.IP(buf[4:8]).String(),
+	}
+	if isSocks4a(buf) {
+		rest := buf[8+bytes.IndexByte(buf[8:], 0)+1:]
+		req.host = string(rest[:bytes.IndexByte(rest, 0)])
+		req.domain = true
+	}
+	return req, nil
+}
+
+// isSocks4a reports whether DSTIP is 0.0.0.x with x != 0, which marks a
+// SOCKS4a domain name following the user ID.
+func isSocks4a(buf []byte) bool {
+	return buf[4] == 0 && buf[5] == 0 && buf[6] == 0 && buf[7] != 0
+}
+
+// socks4Reply builds the 8-byte SOCKS4 reply.
+func socks4Reply(status byte) []byte {
+	return []byte{0x00, status, 0, 0, 0, 0, 0, 0}
+}
+
+// handleSocks4 serves a SOCKS4/4a client for either SOCKS server. SOCKS4 has
+// no password authentication, so it is refused when the forward requires
+// credentials. Only CONNECT is supported; resolve maps a 4a domain name to
+// the address to dial ("" to leave it to dial), and dial connects to the
+// target.
+func handleSocks4(clientConn net.Conn, initial []byte, config *ForwardConfig, commonConfig *CommonConfig,
+	resolve func(host string) (string, error), dial func(target string, commonConfig *CommonConfig) (net.Conn, error)) error {
+	start := time.Now()
+
+	req, err := readSocks4Request(clientConn, initial)
+	if err != nil {
+		return err
+	}
+
+	if socks5AuthRequired(config) {
+		clientConn.Write(socks4Reply(socks4Rejected))
+		return fmt.Errorf("SOCKS4 client rejected: %s requires authentication", config.SectionName)
+	}
+	if req.command != 0x01 {
+		clientConn.Write(socks4Reply(socks4Rejected))
+		return fmt.Errorf("unsupported SOCKS4 command: %d", req.command)
+	}
+
+	requested := net.JoinHostPort(req.host, strconv.Itoa(int(req.port)))
+	host := req.host
+	if req.domain {
+		resolved, err := resolve(host)
+		if err != nil {
+			clientConn.Write(socks4Reply(socks4Rejected))
+			return fmt.Errorf("failed to resolve %s: %v", host, err)
+		}
+		if resolved != "" {
+			host = resolved
+		}
+	}
+	target := net.JoinHostPort(host, strconv.Itoa(int(req.port)))
+	if !checkTarget(config, clientConn.RemoteAddr(), "CONNECT", requested, target) {
+		clientConn.Write(socks4Reply(socks4Rejected))
+		return fmt.Errorf("target %s not allowed", requested)
+	}
+
+	// Without replyAfterConnect, the request is granted before the dial
+	if !config.ReplyAfterConnect {
+		if err := sendReply(clientConn, socks4Reply(socks4Granted)); err != nil {
+			return fmt.Errorf("failed to send success response for %s: %v", target, err)
+		}
+	}
+
+	targetConn, err := dial(target, commonConfig)
+	if err != nil {
+		if config.ReplyAfterConnect {
+			clientConn.Write(socks4Reply(socks4Rejected))
+		}
+		return fmt.Errorf("failed to connect to target %s: %v", target, err)
+	}
+	defer targetConn.Close()
+
+	if config.ReplyAfterConnect {
+		if err := sendSuccessReply(clientConn, targetConn, socks4Reply(socks4Granted)); err != nil {
+			return fmt.Errorf("failed to send success response for %s: %v", target, err)
+		}
+	}
+	if commonConfig.Debug {
+		connLogf(clientConn, "SOCKS4 connection established to %s", target)
+	}
+
+	sent, received := relay(clientConn, targetConn, config, commonConfig)
+	if config.AccessLog {
+		logSocks5Access(config, clientConn, target, start, sent, received)
+	}
+	emitTransfer(config, clientConn.RemoteAddr(), target, start, sent, received)
+	return nil
+}
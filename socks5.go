@@ -0,0 +1,886 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// How long a SOCKS5 BIND waits for the inbound connection
+const socks5BindTimeout = 2 * time.Minute
+
+// How long writing the success reply may take before the client is given up
+const socks5ReplyTimeout = 10 * time.Second
+
+// Default per-attempt timeout for dialing a SOCKS5 target, and the pause
+// between retries when dialRetries is set
+const (
+	socks5DialTimeout    = 30 * time.Second
+	socks5DialRetryDelay = time.Second
+)
+
+type socks5Server struct {
+	// Cancelled when the forward stops, aborting pending dials
+	ctx     context.Context
+	sshConn *ssh.Client
+	config  *ForwardConfig
+}
+
+func (s *socks5Server) handleConnection(clientConn net.Conn, commonConfig *CommonConfig) error {
+	start := time.Now()
+
+	// Read SOCKS5 version and number of authentication methods
+	buf := make([]byte, 256)
+	n, err := clientConn.Read(buf)
+	if err != nil {
+		return fmt.Errorf("failed to read SOCKS5 greeting: %v", err)
+	}
+
+	// SOCKS4/4a clients send version 4
+	if n > 0 && buf[0] == 0x04 {
+		return handleSocks4(clientConn, buf[:n], s.config, commonConfig, s.resolveSocks4, s.dialSocks4)
+	}
+
+	if n < 2 || buf[0] != 0x05 {
+		return fmt.Errorf("invalid SOCKS5 version")
+	}
+
+	// Check if authentication is required
+	requireAuth := socks5AuthRequired(s.config)
+
+	// Parse supported authentication methods
+	numMethods := int(buf[1])
+	if n < 2+numMethods {
+		return fmt.Errorf("invalid authentication methods")
+	}
+
+	supportedMethods := buf[2 : 2+numMethods]
+	var selectedMethod byte = 0xFF // No acceptable methods
+
+	if requireAuth {
+		// Check if client supports username/password authentication (method 0x02)
+		for _, method := range supportedMethods {
+			if method == 0x02 {
+				selectedMethod = 0x02
+				break
+			}
+		}
+	} else {
+		// Check if client supports no authentication (method 0x00)
+		for _, method := range supportedMethods {
+			if method == 0x00 {
+				selectedMethod = 0x00
+				break
+			}
+		}
+		// Clients that only offer username/password get any credentials
+		// accepted; with usernameRouting it is preferred, as the username
+		// picks the server
+		if (selectedMethod == 0xFF && s.config.AcceptAnyAuth) || s.config.UsernameRouting {
+			for _, method := range supportedMethods {
+				if method == 0x02 {
+					selectedMethod = 0x02
+					break
+				}
+			}
+		}
+	}
+
+	// Send authentication method selection response
+	_, err = clientConn.Write([]byte{0x05, selectedMethod})
+	if err != nil {
+		return fmt.Errorf("failed to send auth method response: %v", err)
+	}
+
+	if selectedMethod == 0xFF {
+		return fmt.Errorf("no acceptable authentication methods")
+	}
+
+	// Handle authentication if required
+	sshConn := s.sshConn
+	reconnect := func() (*ssh.Client, error) {
+		conn, _, err := getForwardConnection(s.config)
+		return conn, err
+	}
+	if selectedMethod == 0x02 {
+		username, err := s.handleUsernamePasswordAuth(clientConn, commonConfig)
+		if err != nil {
+			return fmt.Errorf("authentication failed: %v", err)
+		}
+		if s.config.UsernameRouting {
+			if sshConn, err = connManager.GetConnection(username); err != nil {
+				clientConn.Write(socks5Reply(0x01, nil))
+				return fmt.Errorf("failed to get connection for %s: %v", username, err)
+			}
+			reconnect = func() (*ssh.Client, error) { return connManager.GetConnection(username) }
+			if commonConfig.Debug {
+				connLogf(clientConn, "SOCKS5 client %s routed through server %s", clientConn.RemoteAddr(), username)
+			}
+		}
+	}
+
+	// Read connection request
+	n, err = clientConn.Read(buf)
+	if err != nil {
+		return fmt.Errorf("failed to read connection request: %v", err)
+	}
+
+	command := buf[1]
+	if n < 4 || buf[0] != 0x05 || (command != 0x01 && command != 0x02 && command != socks5CommandResolve) {
+		return fmt.Errorf("invalid SOCKS5 connection request")
+	}
+
+	// Parse target address
+	targetAddr, targetPort, err := parseSocks5Address(buf[:n])
+	if err != nil {
+		return err
+	}
+
+	requested := socks5Target(targetAddr, targetPort)
+
+	// Lookups go through the tunnel when a dnsServer is set, as there is no
+	// other way to have the SSH server resolve a name without connecting
+	if command == socks5CommandResolve {
+		resolver := net.DefaultResolver
+		if s.config.Resolve != resolveLocal && s.config.DNSServer != "" {
+			resolver = tunnelResolver(sshConn, s.config.DNSServer)
+		}
+		return handleSocks5Resolve(clientConn, s.config, resolver, targetAddr, requested, commonConfig)
+	}
+
+	// A domain name unix:/path connects to an allowed Unix socket on the SSH
+	// server, whatever the port
+	socket, isSocket := "", false
+	if buf[3] == 0x03 && command == 0x01 {
+		socket, isSocket = unixSocketTarget(s.config, targetAddr)
+	}
+
+	// Domain names are resolved by the SSH server unless resolve=local
+	if buf[3] == 0x03 && s.config.Resolve == resolveLocal && !isSocket {
+		resolved, err := resolveHost(net.DefaultResolver, targetAddr, s.config.IPFamily)
+		if err != nil {
+			clientConn.Write(socks5Reply(0x04, nil))
+			return fmt.Errorf("failed to resolve %s locally: %v", targetAddr, err)
+		}
+		if commonConfig.Debug {
+			connLogf(clientConn, "SOCKS5 resolved %s locally to %s", targetAddr, resolved)
+		}
+		targetAddr = resolved
+	}
+
+	target := socks5Target(targetAddr, targetPort)
+	if isSocket {
+		target = socket
+	}
+
+	commandName := "CONNECT"
+	if command == 0x02 {
+		commandName = "BIND"
+	}
+	if !checkTarget(s.config, clientConn.RemoteAddr(), commandName, requested, target) {
+		clientConn.Write(socks5Reply(0x02, nil))
+		return fmt.Errorf("target %s not allowed", requested)
+	}
+
+	if command == 0x02 {
+		return s.handleBind(clientConn, sshConn, target, start, commonConfig)
+	}
+
+	// Without replyAfterConnect, success is reported before the dial
+	if !s.config.ReplyAfterConnect {
+		if err := sendReply(clientConn, socks5Reply(0x00, nil)); err != nil {
+			return fmt.Errorf("failed to send success response for %s: %v", target, err)
+		}
+	}
+
+	// Connect to target through SSH tunnel
+	remoteConn, err := dialTarget(s.ctx, s.config, target, commonConfig, func(ctx context.Context) (net.Conn, error) {
+		return dialChannel(ctx, sshConn, s.config, target, commonConfig)
+	})
+	if err != nil && isTransportError(err) && connManager.connectionDead(sshConn, err) {
+		remoteConn, err = s.redialOnFreshConnection(clientConn, sshConn, reconnect, target, err, commonConfig)
+	}
+	if err != nil {
+		// Send connection failed response, unless success was already sent
+		if s.config.ReplyAfterConnect {
+			clientConn.Write(socks5Reply(0x05, nil))
+		}
+		return fmt.Errorf("failed to connect to target %s: %v", target, err)
+	}
+	defer remoteConn.Close()
+
+	// Send success response with the address the connection is bound to,
+	// encoded as IPv4 or IPv6 to match its family
+	if s.config.ReplyAfterConnect {
+		if err := sendSuccessReply(clientConn, remoteConn, socks5Reply(0x00, remoteConn.LocalAddr())); err != nil {
+			return fmt.Errorf("failed to send success response for %s: %v", target, err)
+		}
+	}
+
+	if commonConfig.Debug {
+		connLogf(clientConn, "SOCKS5 connection established to %s", target)
+	}
+
+	// Relay data in both directions and wait for both to finish
+	sent, received := relay(clientConn, remoteConn, s.config, commonConfig)
+
+	if s.config.AccessLog {
+		logSocks5Access(s.config, clientConn, target, start, sent, received)
+	}
+	emitTransfer(s.config, clientConn.RemoteAddr(), target, start, sent, received)
+
+	return nil
+}
+
+// redialOnFreshConnection retries, once, a CONNECT whose channel open failed
+// because sshConn broke, as confirmed by connectionDead, on the connection
+// returned by reconnect. The broken
+// connection is dropped first, which also restarts the forward; the retry is
+// therefore not bound to the forward's per-connection context.
+func (s *socks5Server) redialOnFreshConnection(clientConn net.Conn, sshConn *ssh.Client, reconnect func() (*ssh.Client, error), target string, dialErr error, commonConfig *CommonConfig) (net.Conn, error) {
+	connLogf(clientConn, "SSH connection of %s failed while connecting to %s (%v), retrying on a fresh connection",
+		s.config.SectionName, target, dialErr)
+	connManager.RemoveClient(sshConn)
+	conn, err := reconnect()
+	if err != nil {
+		return nil, fmt.Errorf("%v, and no fresh connection: %v", dialErr, err)
+	}
+
+	ctx, cancel := context.WithTimeout(connManager.ctx, targetDialTimeout(s.config))
+	defer cancel()
+	return dialChannel(ctx, conn, s.config, target, commonConfig)
+}
+
+// handleBind implements the BIND command: it listens on the SSH server of
+// sshConn, replies with the bound address, then relays the first inbound
+// connection.
+func (s *socks5Server) handleBind(clientConn net.Conn, sshConn *ssh.Client, target string, start time.Time, commonConfig *CommonConfig) error {
+	bindIP := s.config.RemoteIP
+	if bindIP == "" {
+		bindIP = "0.0.0.0"
+	}
+
+	listener, err := sshConn.Listen("tcp", net.JoinHostPort(bindIP, "0"))
+	if err != nil {
+		clientConn.Write(socks5Reply(0x01, nil))
+		return fmt.Errorf("failed to bind on remote server: %v", err)
+	}
+	defer listener.Close()
+
+	// A wildcard bind is reachable at the SSH server's own address
+	bindAddr := listener.Addr().(*net.TCPAddr)
+	if bindAddr.IP.IsUnspecified() {
+		if serverAddr, ok := sshConn.RemoteAddr().(*net.TCPAddr); ok {
+			bindAddr = &net.TCPAddr{IP: serverAddr.IP, Port: bindAddr.Port}
+		}
+	}
+
+	// First reply: the address the peer should connect to
+	_, err = clientConn.Write(socks5Reply(0x00, bindAddr))
+	if err != nil {
+		return fmt.Errorf("failed to send bind response: %v", err)
+	}
+
+	if commonConfig.Debug {
+		connLogf(clientConn, "SOCKS5 BIND listening on %s for %s", bindAddr, target)
+	}
+
+	// Give up if no inbound connection arrives in time
+	timer := time.AfterFunc(socks5BindTimeout, func() { listener.Close() })
+	incomingConn, err := listener.Accept()
+	timer.Stop()
+	if err != nil {
+		clientConn.Write(socks5Reply(0x01, nil))
+		return fmt.Errorf("no inbound connection for BIND on %s within %v: %v", bindAddr, socks5BindTimeout, err)
+	}
+	defer incomingConn.Close()
+
+	// Second reply: the address of the connecting peer
+	if err := sendSuccessReply(clientConn, incomingConn, socks5Reply(0x00, incomingConn.RemoteAddr())); err != nil {
+		return fmt.Errorf("failed to send bind connection response: %v", err)
+	}
+
+	if commonConfig.Debug {
+		connLogf(clientConn, "SOCKS5 BIND accepted connection from %s", incomingConn.RemoteAddr())
+	}
+
+	// Relay data in both directions and wait for both to finish
+	sent, received := relay(clientConn, incomingConn, s.config, commonConfig)
+
+	if s.config.AccessLog {
+		logSocks5Access(s.config, clientConn, "BIND "+target, start, sent, received)
+	}
+	emitTransfer(s.config, clientConn.RemoteAddr(), "BIND "+target, start, sent, received)
+
+	return nil
+}
+
+// handleUsernamePasswordAuth runs the username/password subnegotiation and
+// returns the accepted username.
+func (s *socks5Server) handleUsernamePasswordAuth(clientConn net.Conn, commonConfig *CommonConfig) (string, error) {
+	buf := make([]byte, 256)
+	n, err := clientConn.Read(buf)
+	if err != nil {
+		return "", fmt.Errorf("failed to read auth request: %v", err)
+	}
+
+	if n < 2 || buf[0] != 0x01 {
+		return "", fmt.Errorf("invalid auth version")
+	}
+
+	// Parse username
+	userLen := int(buf[1])
+	if n < 2+userLen+1 {
+		return "", fmt.Errorf("invalid username length")
+	}
+	username := string(buf[2 : 2+userLen])
+
+	// Parse password
+	passLen := int(buf[2+userLen])
+	if n < 2+userLen+1+passLen {
+		return "", fmt.Errorf("invalid password length")
+	}
+	password := string(buf[2+userLen+1 : 2+userLen+1+passLen])
+
+	// Verify credentials
+	verify := verifySocks5Credentials
+	if s.config.UsernameRouting {
+		verify = verifyRoutingCredentials
+	}
+	if verify(s.config, username, password) {
+		// Authentication successful
+		_, err = clientConn.Write([]byte{0x01, 0x00})
+		if err != nil {
+			return "", fmt.Errorf("failed to send auth success: %v", err)
+		}
+		if commonConfig.Debug {
+			connLogf(clientConn, "SOCKS5 authentication successful for user: %s", username)
+		}
+		return username, nil
+	} else {
+		// Authentication failed
+		_, err = clientConn.Write([]byte{0x01, 0x01})
+		if err != nil {
+			return "", fmt.Errorf("failed to send auth failure: %v", err)
+		}
+		return "", fmt.Errorf("invalid credentials for user: %s", username)
+	}
+}
+
+type reverseSocks5Server struct {
+	// Cancelled when the forward stops, aborting pending dials
+	ctx     context.Context
+	sshConn *ssh.Client
+	config  *ForwardConfig
+}
+
+// resolveSocks4 resolves a SOCKS4a domain name locally when resolve=local,
+// and otherwise leaves it to the SSH server.
+func (s *socks5Server) resolveSocks4(host string) (string, error) {
+	if s.config.Resolve != resolveLocal {
+		return "", nil
+	}
+	return resolveHost(net.DefaultResolver, host, s.config.IPFamily)
+}
+
+// dialSocks4 connects to a SOCKS4 target through the SSH connection.
+func (s *socks5Server) dialSocks4(target string, commonConfig *CommonConfig) (net.Conn, error) {
+	return dialTarget(s.ctx, s.config, target, commonConfig, func(ctx context.Context) (net.Conn, error) {
+		return dialChannel(ctx, s.sshConn, s.config, target, commonConfig)
+	})
+}
+
+func (s *reverseSocks5Server) handleConnection(clientConn net.Conn, commonConfig *CommonConfig) error {
+	start := time.Now()
+
+	// An spf peer with compressRelay opens with the compression handshake
+	if s.config.CompressRelay {
+		var err error
+		if clientConn, err = acceptCompression(clientConn); err != nil {
+			return err
+		}
+	}
+
+	// Read SOCKS5 version and number of authentication methods
+	buf := make([]byte, 256)
+	n, err := clientConn.Read(buf)
+	if err != nil {
+		return fmt.Errorf("failed to read SOCKS5 greeting: %v", err)
+	}
+
+	// SOCKS4/4a clients send version 4
+	if n > 0 && buf[0] == 0x04 {
+		return handleSocks4(clientConn, buf[:n], s.config, commonConfig, s.resolveSocks4, s.dialEgress)
+	}
+
+	if n < 2 || buf[0] != 0x05 {
+		return fmt.Errorf("invalid SOCKS5 version")
+	}
+
+	// Check if authentication is required
+	requireAuth := socks5AuthRequired(s.config)
+
+	// Parse supported authentication methods
+	numMethods := int(buf[1])
+	if n < 2+numMethods {
+		return fmt.Errorf("invalid authentication methods")
+	}
+
+	supportedMethods := buf[2 : 2+numMethods]
+	var selectedMethod byte = 0xFF // No acceptable methods
+
+	if requireAuth {
+		// Check if client supports username/password authentication (method 0x02)
+		for _, method := range supportedMethods {
+			if method == 0x02 {
+				selectedMethod = 0x02
+				break
+			}
+		}
+	} else {
+		// Check if client supports no authentication (method 0x00)
+		for _, method := range supportedMethods {
+			if method == 0x00 {
+				selectedMethod = 0x00
+				break
+			}
+		}
+		// Clients that only offer username/password get any credentials accepted
+		if selectedMethod == 0xFF && s.config.AcceptAnyAuth {
+			for _, method := range supportedMethods {
+				if method == 0x02 {
+					selectedMethod = 0x02
+					break
+				}
+			}
+		}
+	}
+
+	// Send authentication method selection response
+	_, err = clientConn.Write([]byte{0x05, selectedMethod})
+	if err != nil {
+		return fmt.Errorf("failed to send auth method response: %v", err)
+	}
+
+	if selectedMethod == 0xFF {
+		return fmt.Errorf("no acceptable authentication methods")
+	}
+
+	// Handle authentication if required
+	if selectedMethod == 0x02 {
+		err = s.handleUsernamePasswordAuth(clientConn, commonConfig)
+		if err != nil {
+			return fmt.Errorf("authentication failed: %v", err)
+		}
+	}
+
+	// Read connection request
+	n, err = clientConn.Read(buf)
+	if err != nil {
+		return fmt.Errorf("failed to read connection request: %v", err)
+	}
+
+	command := buf[1]
+	if n < 4 || buf[0] != 0x05 || (command != 0x01 && command != socks5CommandResolve) {
+		return fmt.Errorf("invalid SOCKS5 connection request")
+	}
+
+	// Parse target address
+	targetAddr, targetPort, err := parseSocks5Address(buf[:n])
+	if err != nil {
+		return err
+	}
+
+	requested := socks5Target(targetAddr, targetPort)
+
+	if command == socks5CommandResolve {
+		resolver := net.DefaultResolver
+		if s.config.Resolve == resolveRemote {
+			resolver = tunnelResolver(s.sshConn, s.config.DNSServer)
+		}
+		return handleSocks5Resolve(clientConn, s.config, resolver, targetAddr, requested, commonConfig)
+	}
+
+	// Resolve domain names explicitly when a resolve mode is configured,
+	// otherwise the dialer resolves them locally
+	if buf[3] == 0x03 { // Domain name
+		switch s.config.Resolve {
+		case resolveLocal, resolveRemote:
+			resolver := net.DefaultResolver
+			if s.config.Resolve == resolveRemote {
+				resolver = tunnelResolver(s.sshConn, s.config.DNSServer)
+			}
+			resolved, err := resolveHost(resolver, targetAddr, s.config.IPFamily)
+			if err != nil {
+				clientConn.Write(socks5Reply(0x04, nil))
+				return fmt.Errorf("failed to resolve %s (%s): %v", targetAddr, s.config.Resolve, err)
+			}
+			if commonConfig.Debug {
+				connLogf(clientConn, "Reverse SOCKS5 resolved %s (%s) to %s", targetAddr, s.config.Resolve, resolved)
+			}
+			targetAddr = resolved
+		default:
+			// Add DNS resolution debugging for domain names, which an SSH
+			// server resolves itself when it carries the egress
+			if !s.remoteEgress() {
+				_, err := net.LookupIP(targetAddr)
+				if err != nil {
+					connLogf(clientConn, "Reverse SOCKS5 DNS resolution failed for %s: %v", targetAddr, err)
+				}
+			}
+		}
+	}
+
+	target := socks5Target(targetAddr, targetPort)
+
+	if !checkTarget(s.config, clientConn.RemoteAddr(), "CONNECT", requested, target) {
+		clientConn.Write(socks5Reply(0x02, nil))
+		return fmt.Errorf("target %s not allowed", requested)
+	}
+
+	// Without replyAfterConnect, success is reported before the dial
+	if !s.config.ReplyAfterConnect {
+		if err := sendReply(clientConn, socks5Reply(0x00, nil)); err != nil {
+			return fmt.Errorf("failed to send success response for %s: %v", target, err)
+		}
+	}
+
+	// For reverse SOCKS5, we need to connect through the local machine's internet connection
+	// (or the egress server's). This allows the remote server to access the internet through it
+	localConn, err := s.dialEgress(target, commonConfig)
+	if err != nil {
+		if commonConfig.Debug {
+			connLogf(clientConn, "Reverse SOCKS5 connection failed to %s: %v", target, err)
+		}
+		// Send connection failed response, unless success was already sent
+		if s.config.ReplyAfterConnect {
+			clientConn.Write(socks5Reply(0x05, nil))
+		}
+		return fmt.Errorf("failed to connect to target %s through %s: %v", target, s.egressName(), err)
+	}
+	defer localConn.Close()
+
+	// Send success response with the address the connection is bound to,
+	// encoded as IPv4 or IPv6 to match its family
+	if s.config.ReplyAfterConnect {
+		if err := sendSuccessReply(clientConn, localConn, socks5Reply(0x00, localConn.LocalAddr())); err != nil {
+			return fmt.Errorf("failed to send success response for %s: %v", target, err)
+		}
+	}
+
+	if commonConfig.Debug {
+		connLogf(clientConn, "Reverse SOCKS5 connection established: %s", target)
+	}
+
+	// Relay data in both directions and wait for both to finish
+	sent, received := relay(clientConn, localConn, s.config, commonConfig)
+
+	if s.config.AccessLog {
+		logSocks5Access(s.config, clientConn, target, start, sent, received)
+	}
+	emitTransfer(s.config, clientConn.RemoteAddr(), target, start, sent, received)
+
+	return nil
+}
+
+func (s *reverseSocks5Server) handleUsernamePasswordAuth(clientConn net.Conn, commonConfig *CommonConfig) error {
+	buf := make([]byte, 256)
+	n, err := clientConn.Read(buf)
+	if err != nil {
+		return fmt.Errorf("failed to read auth request: %v", err)
+	}
+
+	if n < 2 || buf[0] != 0x01 {
+		return fmt.Errorf("invalid auth version")
+	}
+
+	// Parse username
+	userLen := int(buf[1])
+	if n < 2+userLen+1 {
+		return fmt.Errorf("invalid username length")
+	}
+	username := string(buf[2 : 2+userLen])
+
+	// Parse password
+	passLen := int(buf[2+userLen])
+	if n < 2+userLen+1+passLen {
+		return fmt.Errorf("invalid password length")
+	}
+	password := string(buf[2+userLen+1 : 2+userLen+1+passLen])
+
+	// Verify credentials
+	if verifySocks5Credentials(s.config, username, password) {
+		// Authentication successful
+		_, err = clientConn.Write([]byte{0x01, 0x00})
+		if err != nil {
+			return fmt.Errorf("failed to send auth success: %v", err)
+		}
+		if commonConfig.Debug {
+			connLogf(clientConn, "Reverse SOCKS5 authentication successful for user: %s", username)
+		}
+		return nil
+	} else {
+		// Authentication failed
+		_, err = clientConn.Write([]byte{0x01, 0x01})
+		if err != nil {
+			return fmt.Errorf("failed to send auth failure: %v", err)
+		}
+		return fmt.Errorf("invalid credentials for user: %s", username)
+	}
+}
+
+// outboundLocalAddr returns the source address for a reverse SOCKS5 dial:
+// the next of egressIPs in turn, outboundIP or the first address of
+// dialInterface in the forward's ipFamily (IPv4 unless it is ipv6). A nil
+// address lets the OS choose.
+func outboundLocalAddr(config *ForwardConfig) (net.Addr, error) {
+	if len(config.EgressIPs) > 0 {
+		next := atomic.AddUint32(&config.egressNext, 1) - 1
+		return &net.TCPAddr{IP: config.EgressIPs[next%uint32(len(config.EgressIPs))]}, nil
+	}
+	if config.OutboundIP != "" {
+		return &net.TCPAddr{IP: net.ParseIP(config.OutboundIP)}, nil
+	}
+	if config.DialInterface == "" {
+		return nil, nil
+	}
+
+	iface, err := net.InterfaceByName(config.DialInterface)
+	if err != nil {
+		return nil, fmt.Errorf("interface %s: %v", config.DialInterface, err)
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, fmt.Errorf("interface %s: %v", config.DialInterface, err)
+	}
+	wantIPv6 := config.IPFamily == familyIPv6
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && (ipNet.IP.To4() == nil) == wantIPv6 {
+			return &net.TCPAddr{IP: ipNet.IP}, nil
+		}
+	}
+	if wantIPv6 {
+		return nil, fmt.Errorf("interface %s has no IPv6 address", config.DialInterface)
+	}
+	return nil, fmt.Errorf("interface %s has no IPv4 address", config.DialInterface)
+}
+
+// parseSocks5Address returns the target host and port of a SOCKS5 request,
+// whose address type is its fourth byte.
+func parseSocks5Address(req []byte) (string, uint16, error) {
+	n := len(req)
+	switch req[3] { // Address type
+	case 0x01: // IPv4
+		if n < 10 {
+			return "", 0, fmt.Errorf("invalid IPv4 address length")
+		}
+		return fmt.Sprintf("%d.%d.%d.%d", req[4], req[5], req[6], req[7]), uint16(req[8])<<8 | uint16(req[9]), nil
+	case 0x03: // Domain name
+		if n < 5 {
+			return "", 0, fmt.Errorf("invalid domain name length")
+		}
+		domainLen := int(req[4])
+		if n < 5+domainLen+2 {
+			return "", 0, fmt.Errorf("incomplete domain name")
+		}
+		return string(req[5 : 5+domainLen]), uint16(req[5+domainLen])<<8 | uint16(req[5+domainLen+1]), nil
+	case 0x04: // IPv6
+		if n < 22 {
+			return "", 0, fmt.Errorf("invalid IPv6 address length")
+		}
+		return net.IP(req[4:20]).String(), uint16(req[20])<<8 | uint16(req[21]), nil
+	default:
+		return "", 0, fmt.Errorf("unsupported address type: %d", req[3])
+	}
+}
+
+// socks5Target joins a request's host and port into a dial address,
+// bracketing IPv6 addresses.
+func socks5Target(host string, port uint16) string {
+	return net.JoinHostPort(host, strconv.Itoa(int(port)))
+}
+
+// socks5Reply builds a SOCKS5 reply carrying the given bound address. A nil or
+// non-TCP address is encoded as the IPv4 zero address.
+func socks5Reply(rep byte, addr net.Addr) []byte {
+	ip := net.IPv4zero.To4()
+	port := 0
+	if tcpAddr, ok := addr.(*net.TCPAddr); ok {
+		if ip4 := tcpAddr.IP.To4(); ip4 != nil {
+			ip = ip4
+		} else if tcpAddr.IP != nil {
+			ip = tcpAddr.IP.To16()
+		}
+		port = tcpAddr.Port
+	}
+
+	atyp := byte(0x01)
+	if len(ip) == net.IPv6len {
+		atyp = 0x04
+	}
+
+	response := []byte{0x05, rep, 0x00, atyp}
+	response = append(response, ip...)
+	return append(response, byte(port>>8), byte(port))
+}
+
+// sendSuccessReply writes the success reply for a connection to targetConn.
+// When the client is gone or stops reading, the reply may be partly sent, so
+// both connections are closed right away rather than when the handler
+// returns, and the caller must not start relaying.
+func sendSuccessReply(clientConn, targetConn net.Conn, reply []byte) error {
+	if err := sendReply(clientConn, reply); err != nil {
+		targetConn.Close()
+		return err
+	}
+	return nil
+}
+
+// sendReply writes reply to the client, closing the client connection if
+// that fails. It is used alone for replies sent before the target is dialed.
+func sendReply(clientConn net.Conn, reply []byte) error {
+	clientConn.SetWriteDeadline(time.Now().Add(socks5ReplyTimeout))
+	_, err := clientConn.Write(reply)
+	if err != nil {
+		clientConn.Close()
+		return err
+	}
+	clientConn.SetWriteDeadline(time.Time{})
+	return nil
+}
+
+// dialTarget connects to a SOCKS5 target using dial, bounding each attempt by
+// the forward's dialTimeout and retrying up to dialRetries times before the
+// failure is reported to the client. Targets the forward's circuit breaker
+// has blocked fail immediately, and cancelling ctx aborts the dial.
+func dialTarget(ctx context.Context, config *ForwardConfig, target string, commonConfig *CommonConfig, dial func(ctx context.Context) (net.Conn, error)) (net.Conn, error) {
+	if config.breaker != nil {
+		if ok, remaining := config.breaker.allow(target); !ok {
+			if commonConfig.Debug {
+				log.Printf("SOCKS5 dial to %s skipped, circuit open for another %s", target, remaining.Round(time.Second))
+			}
+			return nil, fmt.Errorf("target %s is failing, not retrying for %s", target, remaining.Round(time.Second))
+		}
+	}
+
+	var err error
+	for attempt := 0; attempt <= config.DialRetries; attempt++ {
+		if attempt > 0 {
+			if commonConfig.Debug {
+				log.Printf("SOCKS5 dial to %s failed (%v), retrying (%d/%d)", target, err, attempt, config.DialRetries)
+			}
+			select {
+			case <-time.After(socks5DialRetryDelay):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+
+		attemptCtx, cancel := context.WithTimeout(ctx, targetDialTimeout(config))
+		var conn net.Conn
+		conn, err = dial(attemptCtx)
+		cancel()
+		if err == nil {
+			if config.breaker != nil {
+				config.breaker.success(target)
+			}
+			return conn, nil
+		}
+	}
+	// A dial aborted by shutdown says nothing about the target
+	if ctx.Err() != nil {
+		return nil, err
+	}
+	if config.breaker != nil && config.breaker.failure(target) {
+		log.Printf("SOCKS5 target %s failed %d times in %s, refusing it for %s",
+			target, config.BreakerFailures, config.BreakerWindow, config.BreakerCooldown)
+	}
+	return nil, err
+}
+
+// targetDialTimeout returns the forward's dialTimeout, or the default.
+func targetDialTimeout(config *ForwardConfig) time.Duration {
+	if config.DialTimeout == 0 {
+		return socks5DialTimeout
+	}
+	return config.DialTimeout
+}
+
+// resolveSocks4 resolves a SOCKS4a domain name as configured by resolve, and
+// otherwise leaves it to the dialer.
+func (s *reverseSocks5Server) resolveSocks4(host string) (string, error) {
+	switch s.config.Resolve {
+	case resolveLocal:
+		return resolveHost(net.DefaultResolver, host, s.config.IPFamily)
+	case resolveRemote:
+		return resolveHost(tunnelResolver(s.sshConn, s.config.DNSServer), host, s.config.IPFamily)
+	}
+	return "", nil
+}
+
+// Values of reverseEgress
+const (
+	reverseEgressLocal  = "local"
+	reverseEgressServer = "server"
+)
+
+// remoteEgress reports whether the reverse SOCKS server's connections leave
+// from an SSH server rather than this machine.
+func (s *reverseSocks5Server) remoteEgress() bool {
+	return s.config.EgressServer != "" || s.config.ReverseEgress == reverseEgressServer
+}
+
+// dialEgress connects to a target of the reverse SOCKS server: through the
+// egressServer's SSH connection when set, through the forward's own SSH
+// server with reverseEgress=server, otherwise from this machine.
+func (s *reverseSocks5Server) dialEgress(target string, commonConfig *CommonConfig) (net.Conn, error) {
+	if s.config.EgressServer != "" {
+		egressConn, err := connManager.GetConnection(s.config.EgressServer)
+		if err != nil {
+			return nil, fmt.Errorf("egress server %s: %v", s.config.EgressServer, err)
+		}
+		return dialTarget(s.ctx, s.config, target, commonConfig, func(ctx context.Context) (net.Conn, error) {
+			return egressConn.DialContext(ctx, "tcp", target)
+		})
+	}
+	if s.config.ReverseEgress == reverseEgressServer {
+		return dialTarget(s.ctx, s.config, target, commonConfig, func(ctx context.Context) (net.Conn, error) {
+			return dialChannel(ctx, s.sshConn, s.config, target, commonConfig)
+		})
+	}
+
+	localAddr, err := outboundLocalAddr(s.config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to determine outbound address: %v", err)
+	}
+	dialer := dscpDialer(s.config)
+	dialer.LocalAddr = localAddr
+	return dialTarget(s.ctx, s.config, target, commonConfig, func(ctx context.Context) (net.Conn, error) {
+		dialStart := time.Now()
+		conn, err := dialer.DialContext(ctx, familyNetwork("tcp", s.config.IPFamily), target)
+		if err == nil {
+			warnSlowDial(target+" for "+s.config.SectionName, dialStart, commonConfig.SlowDialThreshold)
+			if commonConfig.Debug && len(s.config.EgressIPs) > 0 {
+				log.Printf("Reverse SOCKS5 connection to %s leaves from %s", target, conn.LocalAddr())
+			}
+		}
+		return conn, err
+	})
+}
+
+// egressName describes where the reverse SOCKS server's connections leave from.
+func (s *reverseSocks5Server) egressName() string {
+	if s.config.EgressServer != "" {
+		return "egress server " + s.config.EgressServer
+	}
+	if s.config.ReverseEgress == reverseEgressServer {
+		return "SSH server"
+	}
+	return "local connection"
+}
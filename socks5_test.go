@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSocks5Reply(t *testing.T) {
+	tests := []struct {
+		name string
+		rep  byte
+		addr net.Addr
+		want []byte
+	}{
+		{
+			name: "no address",
+			rep:  0x05,
+			want: []byte{0x05, 0x05, 0x00, 0x01, 0, 0, 0, 0, 0, 0},
+		},
+		{
+			name: "IPv4",
+			addr: &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1).To4(), Port: 8080},
+			want: []byte{0x05, 0x00, 0x00, 0x01, 192, 0, 2, 1, 0x1f, 0x90},
+		},
+		{
+			name: "IPv4 in 16-byte form",
+			addr: &net.TCPAddr{IP: net.ParseIP("10.1.2.3"), Port: 1},
+			want: []byte{0x05, 0x00, 0x00, 0x01, 10, 1, 2, 3, 0x00, 0x01},
+		},
+		{
+			name: "IPv6",
+			addr: &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 443},
+			want: []byte{0x05, 0x00, 0x00, 0x04,
+				0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x01,
+				0x01, 0xbb},
+		},
+		{
+			name: "IPv6 loopback, high port",
+			addr: &net.TCPAddr{IP: net.IPv6loopback, Port: 65535},
+			want: []byte{0x05, 0x00, 0x00, 0x04,
+				0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x01,
+				0xff, 0xff},
+		},
+		{
+			name: "not a TCP address",
+			addr: &net.UnixAddr{Name: "/tmp/socket", Net: "unix"},
+			want: []byte{0x05, 0x00, 0x00, 0x01, 0, 0, 0, 0, 0, 0},
+		},
+	}
+	for _, tt := range tests {
+		if got := socks5Reply(tt.rep, tt.addr); !bytes.Equal(got, tt.want) {
+			t.Errorf("%s: got % x, want % x", tt.name, got, tt.want)
+		}
+	}
+}
+
+// A client that hangs up before the success reply must not be relayed, and
+// the target connection must be closed at once.
+func TestConnectClientGoneBeforeReply(t *testing.T) {
+	target, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer target.Close()
+	port := target.Addr().(*net.TCPAddr).Port
+
+	server := &reverseSocks5Server{
+		ctx:    context.Background(),
+		config: &ForwardConfig{SectionName: "test", Direction: "reverse-socks5", ReplyAfterConnect: true},
+	}
+	clientConn, serverConn := net.Pipe()
+	result := make(chan error, 1)
+	go func() {
+		result <- server.handleConnection(serverConn, &CommonConfig{})
+	}()
+
+	// Negotiate no authentication, send a CONNECT and hang up
+	if _, err := clientConn.Write([]byte{0x05, 0x01, 0x00}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(clientConn, make([]byte, 2)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := clientConn.Write([]byte{0x05, 0x01, 0x00, 0x01, 127, 0, 0, 1, byte(port >> 8), byte(port)}); err != nil {
+		t.Fatal(err)
+	}
+	clientConn.Close()
+
+	accepted, err := target.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer accepted.Close()
+
+	select {
+	case err := <-result:
+		if err == nil || !strings.Contains(err.Error(), "failed to send success response") {
+			t.Fatalf("handleConnection returned %v, want a failed success response", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("handleConnection did not return after the client hung up")
+	}
+
+	// Nothing was relayed, and the target sees its connection closed
+	accepted.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if n, err := accepted.Read(make([]byte, 1)); n != 0 || err != io.EOF {
+		t.Fatalf("target read %d bytes, %v; want EOF", n, err)
+	}
+}
+
+func TestParseSocks5Address(t *testing.T) {
+	tests := []struct {
+		name    string
+		req     []byte
+		host    string
+		port    uint16
+		wantErr string
+	}{
+		{
+			name: "IPv4",
+			req:  []byte{0x05, 0x01, 0x00, 0x01, 192, 0, 2, 7, 0x00, 0x50},
+			host: "192.0.2.7",
+			port: 80,
+		},
+		{
+			name: "domain name",
+			req:  append(append([]byte{0x05, 0x01, 0x00, 0x03, 11}, "example.com"...), 0x01, 0xbb),
+			host: "example.com",
+			port: 443,
+		},
+		{
+			name: "IPv6",
+			req: []byte{0x05, 0x01, 0x00, 0x04,
+				0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x01,
+				0x1f, 0x90},
+			host: "2001:db8::1",
+			port: 8080,
+		},
+		{
+			name:    "short IPv4",
+			req:     []byte{0x05, 0x01, 0x00, 0x01, 192, 0, 2, 7, 0x00},
+			wantErr: "invalid IPv4 address length",
+		},
+		{
+			name:    "short domain name",
+			req:     append([]byte{0x05, 0x01, 0x00, 0x03, 11}, "example"...),
+			wantErr: "incomplete domain name",
+		},
+		{
+			name:    "short IPv6",
+			req:     []byte{0x05, 0x01, 0x00, 0x04, 0x20, 0x01, 0x0d, 0xb8},
+			wantErr: "invalid IPv6 address length",
+		},
+		{
+			name:    "unknown address type",
+			req:     []byte{0x05, 0x01, 0x00, 0x05, 127, 0, 0, 1, 0x00, 0x50},
+			wantErr: "unsupported address type: 5",
+		},
+	}
+	for _, tt := range tests {
+		host, port, err := parseSocks5Address(tt.req)
+		if tt.wantErr != "" {
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("%s: got error %v, want %q", tt.name, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || host != tt.host || port != tt.port {
+			t.Errorf("%s: got %q, %d, %v; want %q, %d", tt.name, host, port, err, tt.host, tt.port)
+		}
+	}
+}
+
+func TestSocks5Target(t *testing.T) {
+	tests := []struct {
+		name string
+		req  []byte
+		want string
+	}{
+		{"IPv4", []byte{0x05, 0x01, 0x00, 0x01, 192, 0, 2, 7, 0x00, 0x50}, "192.0.2.7:80"},
+		{"domain name", append(append([]byte{0x05, 0x01, 0x00, 0x03, 11}, "example.com"...), 0x01, 0xbb), "example.com:443"},
+		{"IPv6", []byte{0x05, 0x01, 0x00, 0x04,
+			0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x01,
+			0x1f, 0x90}, "[2001:db8::1]:8080"},
+	}
+	for _, tt := range tests {
+		host, port, err := parseSocks5Address(tt.req)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		target := socks5Target(host, port)
+		if target != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, target, tt.want)
+		}
+		if splitHost, splitPort, err := net.SplitHostPort(target); err != nil || splitHost != host || splitPort != strconv.Itoa(int(port)) {
+			t.Errorf("%s: SplitHostPort(%q) = %q, %q, %v", tt.name, target, splitHost, splitPort, err)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strings"
+)
+
+// parseAllowSource parses the allowSource list of a forward with a local
+// listener. Entries are CIDR blocks or single addresses.
+func parseAllowSource(fc *ForwardConfig, value string) error {
+	entries := splitList(value)
+	if len(entries) == 0 {
+		return nil
+	}
+	switch fc.Direction {
+	case "local", "socks5", "sni-route":
+	default:
+		return fmt.Errorf("allowSource on %s is only supported for directions with a local listener", fc.SectionName)
+	}
+
+	for _, entry := range entries {
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return fmt.Errorf("invalid allowSource entry %q for %s", entry, fc.SectionName)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			fc.AllowSource = append(fc.AllowSource, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return fmt.Errorf("invalid allowSource entry %q for %s", entry, fc.SectionName)
+		}
+		fc.AllowSource = append(fc.AllowSource, network)
+	}
+	return nil
+}
+
+// wrapListenerSource returns listener filtered to the forward's allowSource
+// networks, or listener itself when none are set.
+func wrapListenerSource(listener net.Listener, fc *ForwardConfig) net.Listener {
+	if len(fc.AllowSource) == 0 {
+		return listener
+	}
+	return &sourceListener{Listener: listener, config: fc}
+}
+
+// sourceListener closes connections from addresses outside allowSource as
+// soon as they are accepted.
+type sourceListener struct {
+	net.Listener
+	config *ForwardConfig
+}
+
+func (l *sourceListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		if l.allowed(conn.RemoteAddr()) {
+			return conn, nil
+		}
+		log.Printf("Rejected connection from %s to %s: not in allowSource", conn.RemoteAddr(), l.config.SectionName)
+		conn.Close()
+	}
+}
+
+func (l *sourceListener) allowed(addr net.Addr) bool {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	for _, network := range l.config.AllowSource {
+		if network.Contains(tcpAddr.IP) {
+			return true
+		}
+	}
+	return false
+}
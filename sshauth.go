@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// SSH authentication methods selectable with authOrder
+const (
+	authKey      = "key"
+	authAgent    = "agent"
+	authPassword = "password"
+)
+
+// Order used when a server sets no authOrder
+var defaultAuthOrder = []string{authKey, authPassword}
+
+// sshAuth holds the authentication methods for one dial and records which
+// of them the server asked for.
+type sshAuth struct {
+	Methods []ssh.AuthMethod
+
+	mutex     sync.Mutex
+	used      string
+	agentConn net.Conn
+}
+
+// newSSHAuth builds a server's authentication methods in its authOrder.
+// Methods without credentials (no identityFile, no password, no running
+// agent) are skipped.
+func newSSHAuth(serverConfig *ServerConfig) (*sshAuth, error) {
+	auth := &sshAuth{}
+	order := serverConfig.AuthOrder
+	if len(order) == 0 {
+		order = defaultAuthOrder
+	}
+
+	for _, method := range order {
+		switch method {
+		case authKey:
+			if serverConfig.IdentityFile == "" {
+				continue
+			}
+			key, err := os.ReadFile(expandHome(serverConfig.IdentityFile))
+			if err != nil {
+				return nil, fmt.Errorf("failed to read identity file: %v", err)
+			}
+			signer, err := ssh.ParsePrivateKey(key)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse identity file %s: %v", serverConfig.IdentityFile, err)
+			}
+			auth.Methods = append(auth.Methods, ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
+				auth.record(authKey)
+				return []ssh.Signer{signer}, nil
+			}))
+		case authAgent:
+			socket := os.Getenv("SSH_AUTH_SOCK")
+			if socket == "" {
+				continue
+			}
+			conn, err := net.Dial("unix", socket)
+			if err != nil {
+				log.Printf("Warning: ssh-agent unavailable: %v", err)
+				continue
+			}
+			auth.agentConn = conn
+			agentClient := agent.NewClient(conn)
+			auth.Methods = append(auth.Methods, ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
+				auth.record(authAgent)
+				return agentClient.Signers()
+			}))
+		case authPassword:
+			if serverConfig.Password == "" {
+				continue
+			}
+			password := serverConfig.Password
+			auth.Methods = append(auth.Methods, ssh.PasswordCallback(func() (string, error) {
+				auth.record(authPassword)
+				return password, nil
+			}))
+		}
+	}
+	return auth, nil
+}
+
+func (a *sshAuth) record(method string) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.used = method
+}
+
+// Used returns the method that authenticated the connection. The client tries
+// methods in order and stops at the first that succeeds, so after a
+// successful dial this is the last one invoked.
+func (a *sshAuth) Used() string {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.used
+}
+
+// Close releases the ssh-agent connection, which is only needed while
+// authenticating.
+func (a *sshAuth) Close() {
+	if a.agentConn != nil {
+		a.agentConn.Close()
+	}
+}
+
+// validateAuthOrder checks an authOrder list.
+func validateAuthOrder(order []string) error {
+	for _, method := range order {
+		if method != authKey && method != authAgent && method != authPassword {
+			return fmt.Errorf("invalid authOrder entry %q (expected key, agent or password)", method)
+		}
+	}
+	return nil
+}
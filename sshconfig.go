@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/kevinburke/ssh_config"
+)
+
+// applySSHConfigHost fills the server fields left empty in config.ini from the
+// ~/.ssh/config (and /etc/ssh/ssh_config) entry for alias.
+func applySSHConfigHost(serverConfig *ServerConfig, alias string) error {
+	get := func(key string) (string, error) {
+		value, err := ssh_config.GetStrict(alias, key)
+		if err != nil {
+			return "", fmt.Errorf("failed to read ssh config for %s: %v", alias, err)
+		}
+		return value, nil
+	}
+
+	if serverConfig.Server == "" {
+		hostName, err := get("HostName")
+		if err != nil {
+			return err
+		}
+		if hostName == "" {
+			hostName = alias
+		}
+		serverConfig.Server = hostName
+	}
+	if serverConfig.User == "" {
+		user, err := get("User")
+		if err != nil {
+			return err
+		}
+		serverConfig.User = user
+	}
+	if serverConfig.Port == "" {
+		port, err := get("Port")
+		if err != nil {
+			return err
+		}
+		serverConfig.Port = port
+	}
+	if serverConfig.IdentityFile == "" {
+		identityFiles, err := ssh_config.GetAllStrict(alias, "IdentityFile")
+		if err != nil {
+			return fmt.Errorf("failed to read ssh config for %s: %v", alias, err)
+		}
+		// Use the first key that exists, skipping the built-in default
+		for _, identityFile := range identityFiles {
+			if _, err := os.Stat(expandHome(identityFile)); err == nil {
+				serverConfig.IdentityFile = identityFile
+				break
+			}
+		}
+	}
+	if len(serverConfig.ProxyJump) == 0 {
+		proxyJump, err := get("ProxyJump")
+		if err != nil {
+			return err
+		}
+		if proxyJump != "" && proxyJump != "none" {
+			if serverConfig.ProxyJump, err = parseProxyJump(proxyJump); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// expandHome replaces a leading ~/ with the user's home directory.
+func expandHome(path string) string {
+	if !strings.HasPrefix(path, "~/") {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(home, path[2:])
+}
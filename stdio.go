@@ -0,0 +1,87 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+)
+
+// runStdio connects stdin and stdout to the target of the stdio forward
+// section through its SSH connection until the target closes, so spf can be
+// used as an ssh ProxyCommand. args may give the target as host and port,
+// overriding the section's remoteIP and remotePort. It returns the exit code.
+func runStdio(config *Config, section string, args []string) int {
+	if errs := validateConfig(config); len(errs) > 0 {
+		fmt.Fprintf(os.Stderr, "Invalid configuration: %v\n", errors.Join(errs...))
+		return 1
+	}
+	// Log output would end up on the terminal of the ssh client
+	if !config.Common.Debug {
+		log.SetOutput(io.Discard)
+	}
+	if err := connectStdio(config, section, args); err != nil {
+		fmt.Fprintf(os.Stderr, "spf: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+func connectStdio(config *Config, section string, args []string) error {
+	var fc *ForwardConfig
+	for _, forwardConfig := range config.Forwards {
+		if forwardConfig.SectionName == section {
+			fc = forwardConfig
+		}
+	}
+	if fc == nil || fc.Direction != "stdio" {
+		return fmt.Errorf("no forward section %s with direction=stdio", section)
+	}
+
+	host, port := fc.RemoteIP, fc.RemotePort
+	switch len(args) {
+	case 0:
+	case 2:
+		host, port = args[0], args[1]
+	default:
+		return fmt.Errorf("expected host and port after the section name")
+	}
+	if port == "" {
+		return fmt.Errorf("no target for %s: set remotePort or pass host and port", section)
+	}
+	if host == "" {
+		host = "localhost"
+	}
+	target := net.JoinHostPort(host, port)
+
+	publishServers(config.Servers)
+	defer connManager.CloseAll()
+	conn, serverName, err := getForwardConnection(fc)
+	if err != nil {
+		return fmt.Errorf("failed to get connection for %s: %v", fc.ServerName, err)
+	}
+	remoteConn, err := dialChannel(ctx, conn, fc, target, config.Common)
+	if err != nil {
+		return fmt.Errorf("failed to connect to %s through %s: %v", target, serverName, err)
+	}
+	defer remoteConn.Close()
+	log.Printf("Connected stdin/stdout to %s through %s", target, serverName)
+
+	// End of input is passed on as a half-close, the target's end of output
+	// ends the session
+	go func() {
+		io.Copy(remoteConn, os.Stdin)
+		if halfCloser, ok := remoteConn.(interface{ CloseWrite() error }); ok {
+			halfCloser.CloseWrite()
+		} else {
+			remoteConn.Close()
+		}
+	}()
+	_, err = io.Copy(os.Stdout, remoteConn)
+	return err
+}
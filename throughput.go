@@ -0,0 +1,69 @@
+package main
+
+import (
+	"io"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// Shortest throughputInterval accepted, so samples stay meaningful
+const minThroughputInterval = time.Second
+
+// throughputSampler logs the throughput of one relayed connection every
+// throughputInterval. The relay's copies add the bytes they read to the
+// current bucket, which each tick empties.
+type throughputSampler struct {
+	sent     atomic.Int64 // bytes from the client in the current bucket
+	received atomic.Int64 // bytes from the target in the current bucket
+	done     chan struct{}
+}
+
+// startThroughputSampler starts sampling a connection relayed by config.
+// Buckets without traffic are not logged, so idle connections stay quiet.
+func startThroughputSampler(clientConn net.Conn, config *ForwardConfig, interval time.Duration) *throughputSampler {
+	s := &throughputSampler{done: make(chan struct{})}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		last := time.Now()
+		for {
+			select {
+			case <-s.done:
+				return
+			case now := <-ticker.C:
+				sent, received := s.sent.Swap(0), s.received.Swap(0)
+				elapsed := now.Sub(last).Seconds()
+				last = now
+				if sent == 0 && received == 0 {
+					continue
+				}
+				connLogf(clientConn, "Throughput forward=%s client=%s sent=%.2fMbps received=%.2fMbps",
+					config.SectionName, clientConn.RemoteAddr(), mbps(sent, elapsed), mbps(received, elapsed))
+			}
+		}
+	}()
+	return s
+}
+
+// stop ends sampling; the partial last bucket is dropped.
+func (s *throughputSampler) stop() {
+	close(s.done)
+}
+
+// mbps converts bytes moved in seconds to megabits per second.
+func mbps(bytes int64, seconds float64) float64 {
+	return float64(bytes) * 8 / seconds / 1e6
+}
+
+// countingReader adds the bytes read through it to a sampler bucket.
+type countingReader struct {
+	io.Reader
+	bucket *atomic.Int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.bucket.Add(int64(n))
+	return n, err
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+	"time"
+)
+
+// How long a client of a TLS listener has to complete the handshake
+const tlsHandshakeTimeout = 10 * time.Second
+
+// loadListenerTLS builds the TLS config for a socks5 or local forward with
+// tlsCert and tlsKey set. With tlsClientCA, clients must also present a
+// certificate signed by that CA before they reach the SOCKS5 negotiation or
+// the tunnel.
+func loadListenerTLS(fc *ForwardConfig) error {
+	if fc.TLSCert == "" && fc.TLSKey == "" && fc.TLSClientCA == "" {
+		return nil
+	}
+	if fc.Direction != "socks5" && fc.Direction != "local" {
+		return fmt.Errorf("tlsCert/tlsKey/tlsClientCA on %s are only supported for directions socks5 and local", fc.SectionName)
+	}
+	if fc.TLSCert == "" || fc.TLSKey == "" {
+		return fmt.Errorf("%s needs both tlsCert and tlsKey", fc.SectionName)
+	}
+
+	cert, err := tls.LoadX509KeyPair(expandHome(fc.TLSCert), expandHome(fc.TLSKey))
+	if err != nil {
+		return fmt.Errorf("failed to load TLS certificate for %s: %v", fc.SectionName, err)
+	}
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if fc.TLSClientCA != "" {
+		caPEM, err := os.ReadFile(expandHome(fc.TLSClientCA))
+		if err != nil {
+			return fmt.Errorf("failed to read tlsClientCA for %s: %v", fc.SectionName, err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return fmt.Errorf("no certificates found in tlsClientCA %s", fc.TLSClientCA)
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	fc.tlsConfig = tlsConfig
+	return nil
+}
+
+// wrapListenerTLS returns listener wrapped in TLS when the forward has a TLS
+// config, and listener itself otherwise.
+func wrapListenerTLS(listener net.Listener, fc *ForwardConfig) net.Listener {
+	if fc.tlsConfig == nil {
+		return listener
+	}
+	return tls.NewListener(listener, fc.tlsConfig)
+}
+
+// completeHandshake finishes the TLS handshake of a connection accepted by a
+// TLS listener, so a client failing it is turned away before a channel is
+// opened for it. Other connections are left as they are.
+func completeHandshake(ctx context.Context, conn net.Conn) error {
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		return nil
+	}
+	handshakeCtx, cancel := context.WithTimeout(ctx, tlsHandshakeTimeout)
+	defer cancel()
+	return tlsConn.HandshakeContext(handshakeCtx)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"path"
+	"strings"
+
+	"gopkg.in/ini.v1"
+)
+
+// Prefix of a SOCKS5 domain name asking for a Unix socket on the SSH server,
+// e.g. unix:/var/run/docker.sock
+const unixSocketPrefix = "unix:"
+
+// parseUnixSockets reads remoteSocket of a local forward and
+// socks5UnixSockets of a socks5 forward. Both name absolute paths on the SSH
+// server.
+func parseUnixSockets(fc *ForwardConfig, section *ini.Section) error {
+	fc.RemoteSocket = section.Key("remoteSocket").String()
+	fc.Socks5UnixSockets = splitList(section.Key("socks5UnixSockets").String())
+
+	if fc.RemoteSocket != "" {
+		if fc.Direction != "local" {
+			return fmt.Errorf("remoteSocket for %s is only supported with direction local", fc.SectionName)
+		}
+		if fc.RemoteIP != "" || fc.RemotePort != "" {
+			return fmt.Errorf("remoteSocket for %s replaces remoteIP and remotePort, set only one", fc.SectionName)
+		}
+		if !path.IsAbs(fc.RemoteSocket) {
+			return fmt.Errorf("invalid remoteSocket %q for %s (expected an absolute path)", fc.RemoteSocket, fc.SectionName)
+		}
+	}
+	if len(fc.Socks5UnixSockets) > 0 && fc.Direction != "socks5" {
+		return fmt.Errorf("socks5UnixSockets for %s is only supported with direction socks5", fc.SectionName)
+	}
+	for _, socket := range fc.Socks5UnixSockets {
+		if !path.IsAbs(socket) {
+			return fmt.Errorf("invalid socks5UnixSockets entry %q for %s (expected an absolute path)", socket, fc.SectionName)
+		}
+	}
+	return nil
+}
+
+// remoteTarget returns the address a local forward connects to through the
+// tunnel: its remoteSocket path or remoteIP:remotePort.
+func (fc *ForwardConfig) remoteTarget() string {
+	if fc.RemoteSocket != "" {
+		return fc.RemoteSocket
+	}
+	return net.JoinHostPort(fc.RemoteIP, fc.RemotePort)
+}
+
+// unixSocketTarget returns the socket path requested by a SOCKS5 domain name
+// of the form unix:/path, if the forward allows it in socks5UnixSockets.
+func unixSocketTarget(config *ForwardConfig, host string) (string, bool) {
+	socket, ok := strings.CutPrefix(host, unixSocketPrefix)
+	if !ok {
+		return "", false
+	}
+	for _, allowed := range config.Socks5UnixSockets {
+		if socket == allowed {
+			return socket, true
+		}
+	}
+	return "", false
+}
+
+// channelNetwork returns the network for dialing addr through the tunnel:
+// unix for an absolute socket path, otherwise tcp.
+func channelNetwork(addr string) string {
+	if strings.HasPrefix(addr, "/") {
+		return "unix"
+	}
+	return "tcp"
+}
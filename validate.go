@@ -0,0 +1,217 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// validateConfig checks the parsed configuration for problems that would only
+// show up at runtime, and returns all of them.
+func validateConfig(config *Config) []error {
+	var errs []error
+
+	var serverNames []string
+	for name := range config.Servers {
+		serverNames = append(serverNames, name)
+	}
+	sort.Strings(serverNames)
+
+	for _, name := range serverNames {
+		serverConfig := config.Servers[name]
+		if serverConfig.Server == "" {
+			errs = append(errs, fmt.Errorf("[%s] server address is empty", name))
+		}
+		if err := validatePort(serverConfig.Port); err != nil {
+			errs = append(errs, fmt.Errorf("[%s] port: %v", name, err))
+		}
+		if serverConfig.User == "" {
+			errs = append(errs, fmt.Errorf("[%s] user is empty", name))
+		}
+		if serverConfig.Password == "" && serverConfig.IdentityFile == "" && !serverConfig.PromptPassword && !containsString(serverConfig.AuthOrder, authAgent) {
+			errs = append(errs, fmt.Errorf("[%s] password, identityFile, promptPassword or an authOrder with agent is required", name))
+		}
+	}
+
+	for _, fc := range config.Forwards {
+		var required []string
+		switch fc.Direction {
+		case "local":
+			required = []string{"localPort", "remoteIP", "remotePort"}
+			if fc.RemoteSocket != "" {
+				required = []string{"localPort"}
+			}
+		case "remote":
+			required = []string{"remotePort", "localIP", "localPort"}
+		case "socks5":
+			required = []string{"localPort"}
+		case "sni-route":
+			required = []string{"localPort"}
+			if len(fc.SNIRoutes) == 0 && (fc.RemoteIP == "" || fc.RemotePort == "") {
+				errs = append(errs, fmt.Errorf("[%s] sniRoutes or remoteIP and remotePort are required for direction sni-route", fc.SectionName))
+			}
+		case "reverse-socks5":
+			required = []string{"remotePort"}
+		case "stdio":
+			// The target may also be given on the command line
+			if fc.RemotePort != "" {
+				required = []string{"remotePort"}
+			}
+		default:
+			errs = append(errs, fmt.Errorf("[%s] invalid direction: %s", fc.SectionName, fc.Direction))
+			continue
+		}
+
+		values := map[string]string{
+			"localIP":    fc.LocalIP,
+			"localPort":  fc.LocalPort,
+			"remoteIP":   fc.RemoteIP,
+			"remotePort": fc.RemotePort,
+		}
+		for _, key := range required {
+			if values[key] == "" {
+				errs = append(errs, fmt.Errorf("[%s] %s is required for direction %s", fc.SectionName, key, fc.Direction))
+			} else if strings.HasSuffix(key, "Port") && !(key == "remotePort" && len(fc.RemotePorts) > 0) {
+				if err := validatePort(values[key]); err != nil {
+					errs = append(errs, fmt.Errorf("[%s] %s: %v", fc.SectionName, key, err))
+				}
+			}
+		}
+	}
+
+	errs = append(errs, validateListenConflicts(config.Forwards)...)
+	return errs
+}
+
+// listenBind is an address a forward listens on. Scope is "local" for
+// listeners on this machine, or the server name for remote binds.
+type listenBind struct {
+	section string
+	scope   string
+	host    string
+	port    string
+}
+
+// validateListenConflicts reports forwards that would listen on the same
+// address, either locally or on the same SSH server. A wildcard host overlaps
+// every address on its port, and port 0 never conflicts.
+func validateListenConflicts(forwardConfigs []*ForwardConfig) []error {
+	var binds []listenBind
+	for _, fc := range forwardConfigs {
+		switch fc.Direction {
+		case "local", "socks5", "sni-route":
+			binds = append(binds, listenBind{fc.SectionName, "local", fc.LocalIP, fc.LocalPort})
+		case "remote", "reverse-socks5":
+			ports := fc.RemotePorts
+			if len(ports) == 0 {
+				ports = []string{fc.RemotePort}
+			}
+			for _, serverName := range fc.ServerNames {
+				for _, port := range ports {
+					binds = append(binds, listenBind{fc.SectionName, serverName, fc.RemoteIP, port})
+				}
+			}
+		}
+	}
+
+	var errs []error
+	for i := range binds {
+		for j := i + 1; j < len(binds); j++ {
+			a, b := binds[i], binds[j]
+			if a.section == b.section || a.scope != b.scope || a.port != b.port || a.port == "" || a.port == "0" {
+				continue
+			}
+			hostA, hostB := normalizeListenHost(a.host), normalizeListenHost(b.host)
+			if hostA != hostB && hostA != "" && hostB != "" {
+				continue
+			}
+			if a.scope == "local" {
+				errs = append(errs, fmt.Errorf("[%s] and [%s] both listen on %s", a.section, b.section, net.JoinHostPort(a.host, a.port)))
+			} else {
+				errs = append(errs, fmt.Errorf("[%s] and [%s] both bind %s on server %s", a.section, b.section, net.JoinHostPort(a.host, a.port), a.scope))
+			}
+		}
+	}
+	return errs
+}
+
+// normalizeListenHost maps equivalent spellings of a listen host to one form,
+// with "" standing for all interfaces.
+func normalizeListenHost(host string) string {
+	switch strings.ToLower(host) {
+	case "", "0.0.0.0", "::", "*":
+		return ""
+	case "localhost":
+		return "127.0.0.1"
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.String()
+	}
+	return strings.ToLower(host)
+}
+
+// normalizePort returns port in numeric form, accepting a number or a service
+// name from /etc/services such as "https".
+func normalizePort(port string) (string, error) {
+	if n, err := strconv.Atoi(port); err == nil {
+		if n < 0 || n > 65535 {
+			return "", fmt.Errorf("port %d out of range", n)
+		}
+		return strconv.Itoa(n), nil
+	}
+	n, err := net.LookupPort("tcp", port)
+	if err != nil {
+		return "", fmt.Errorf("unknown port or service %q", port)
+	}
+	return strconv.Itoa(n), nil
+}
+
+func validatePort(port string) error {
+	n, err := strconv.Atoi(port)
+	if err != nil || n < 0 || n > 65535 {
+		return fmt.Errorf("invalid port %q", port)
+	}
+	return nil
+}
+
+// printConfigSummary writes a human-readable list of the servers and forwards
+// that would be started.
+func printConfigSummary(w io.Writer, config *Config) {
+	var serverNames []string
+	for name := range config.Servers {
+		serverNames = append(serverNames, name)
+	}
+	sort.Strings(serverNames)
+
+	fmt.Fprintf(w, "Servers (%d):\n", len(serverNames))
+	for _, name := range serverNames {
+		serverConfig := config.Servers[name]
+		fmt.Fprintf(w, "  %-20s %s@%s:%s\n", name, serverConfig.User, serverConfig.Server, serverConfig.Port)
+	}
+
+	fmt.Fprintf(w, "Forwards (%d):\n", len(config.Forwards))
+	for _, fc := range config.Forwards {
+		var detail string
+		switch fc.Direction {
+		case "local":
+			detail = fmt.Sprintf("%s:%s -> %s", fc.LocalIP, fc.LocalPort, fc.remoteTarget())
+		case "remote":
+			detail = fmt.Sprintf("%s:%s -> %s:%s", fc.RemoteIP, fc.RemotePort, fc.LocalIP, fc.LocalPort)
+		case "socks5":
+			detail = fmt.Sprintf("SOCKS5 on %s:%s", fc.LocalIP, fc.LocalPort)
+		case "reverse-socks5":
+			detail = fmt.Sprintf("SOCKS5 on remote %s:%s", fc.RemoteIP, fc.RemotePort)
+		case "sni-route":
+			detail = fmt.Sprintf("SNI routing on %s:%s (%d routes)", fc.LocalIP, fc.LocalPort, len(fc.SNIRoutes))
+		case "stdio":
+			detail = fmt.Sprintf("stdin/stdout -> %s:%s (with spf -stdio only)", fc.RemoteIP, fc.RemotePort)
+		}
+		if fc.SSHConfig == nil {
+			detail += " (no server configured, will not start)"
+		}
+		fmt.Fprintf(w, "  %-20s %-15s via %-15s %s\n", fc.SectionName, fc.Direction, fc.ServerName, detail)
+	}
+}
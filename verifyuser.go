@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// verifyRemoteUser runs `id -un` (or `whoami` where id is missing) on conn
+// and warns when the account the server logged us into is not the configured
+// user, e.g. because the key is mapped to another account.
+func verifyRemoteUser(serverName string, serverConfig *ServerConfig, conn *ssh.Client) {
+	remoteUser, err := remoteUserName(conn)
+	if err != nil {
+		log.Printf("Warning: could not verify the remote user on %s: %v", serverName, err)
+		return
+	}
+	if remoteUser != serverConfig.User {
+		log.Printf("Warning: logged in to %s as %q, but the configured user is %q", serverName, remoteUser, serverConfig.User)
+		return
+	}
+	log.Printf("Verified remote user %s on %s", remoteUser, serverName)
+}
+
+// remoteUserName returns the name of the account conn is logged into.
+func remoteUserName(conn *ssh.Client) (string, error) {
+	var lastErr error
+	for _, command := range []string{"id -un", "whoami"} {
+		session, err := conn.NewSession()
+		if err != nil {
+			return "", fmt.Errorf("failed to open session: %v", err)
+		}
+		output, err := session.Output(command)
+		session.Close()
+		if err == nil {
+			if name := strings.TrimSpace(string(output)); name != "" {
+				return name, nil
+			}
+			err = fmt.Errorf("empty output")
+		}
+		lastErr = fmt.Errorf("%s: %v", command, err)
+	}
+	return "", lastErr
+}
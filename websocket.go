@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// SSH transports selectable with the transport server option
+const (
+	transportTCP       = "tcp"
+	transportWebSocket = "websocket"
+)
+
+// websocketDialFunc returns a DialFunc that carries SSH over a WebSocket to
+// wsURL, for networks that only allow outbound HTTP(S). The server side needs
+// a WebSocket-to-SSH bridge (e.g. websockify or wstunnel) at that URL.
+func websocketDialFunc(wsURL string) func(network, address string) (net.Conn, error) {
+	dialer := &websocket.Dialer{
+		Proxy:            http.ProxyFromEnvironment,
+		HandshakeTimeout: 10 * time.Second,
+	}
+	return func(network, address string) (net.Conn, error) {
+		ws, _, err := dialer.Dial(wsURL, nil)
+		if err != nil {
+			return nil, fmt.Errorf("websocket dial %s: %v", wsURL, err)
+		}
+		return &wsConn{Conn: ws}, nil
+	}
+}
+
+// validateWebSocketURL checks a wsURL option and returns its host.
+func validateWebSocketURL(wsURL string) (string, error) {
+	u, err := url.Parse(wsURL)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme != "ws" && u.Scheme != "wss" {
+		return "", fmt.Errorf("wsURL must start with ws:// or wss://")
+	}
+	if u.Hostname() == "" {
+		return "", fmt.Errorf("wsURL has no host")
+	}
+	return u.Hostname(), nil
+}
+
+// wsConn adapts a WebSocket to net.Conn, sending the byte stream as binary
+// messages.
+type wsConn struct {
+	*websocket.Conn
+	reader io.Reader
+}
+
+func (c *wsConn) Read(p []byte) (int, error) {
+	for {
+		if c.reader == nil {
+			messageType, reader, err := c.NextReader()
+			if err != nil {
+				return 0, err
+			}
+			if messageType != websocket.BinaryMessage {
+				continue
+			}
+			c.reader = reader
+		}
+
+		n, err := c.reader.Read(p)
+		if err == io.EOF {
+			c.reader = nil
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		return n, err
+	}
+}
+
+func (c *wsConn) Write(p []byte) (int, error) {
+	if err := c.WriteMessage(websocket.BinaryMessage, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (c *wsConn) SetDeadline(t time.Time) error {
+	if err := c.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.SetWriteDeadline(t)
+}
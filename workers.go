@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+
+	"gopkg.in/ini.v1"
+)
+
+// workerOverflow values: wait for room in the queue, or close the connection
+const (
+	overflowBlock = "block"
+	overflowDrop  = "drop"
+)
+
+// Default number of accepted connections waiting for a free worker
+const defaultWorkerQueueSize = 128
+
+// parseWorkerPool reads workerPoolSize, workerQueueSize and workerOverflow.
+func parseWorkerPool(fc *ForwardConfig, section *ini.Section) error {
+	fc.WorkerPoolSize = section.Key("workerPoolSize").MustInt(0)
+	fc.WorkerQueueSize = section.Key("workerQueueSize").MustInt(defaultWorkerQueueSize)
+	fc.WorkerOverflow = section.Key("workerOverflow").MustString(overflowBlock)
+
+	if fc.WorkerPoolSize < 0 {
+		return fmt.Errorf("invalid workerPoolSize %d for %s", fc.WorkerPoolSize, fc.SectionName)
+	}
+	if fc.WorkerQueueSize < 0 {
+		return fmt.Errorf("invalid workerQueueSize %d for %s", fc.WorkerQueueSize, fc.SectionName)
+	}
+	if fc.WorkerOverflow != overflowBlock && fc.WorkerOverflow != overflowDrop {
+		return fmt.Errorf("invalid workerOverflow %q for %s (expected block or drop)", fc.WorkerOverflow, fc.SectionName)
+	}
+	if fc.WorkerPoolSize > 0 && fc.Direction == "stdio" {
+		return fmt.Errorf("workerPoolSize does not apply to direction stdio in %s", fc.SectionName)
+	}
+	return nil
+}
+
+// workerJob is an accepted connection and the handler to run for it
+type workerJob struct {
+	conn   net.Conn
+	handle func()
+}
+
+// workerPool handles a forward's accepted connections with a fixed number
+// of goroutines, so the accept loop keeps draining the listen backlog under
+// bursts while connections are processed at a bounded rate
+type workerPool struct {
+	jobs    chan workerJob
+	drop    bool
+	section string
+
+	// Held while queueing, so no job is queued once the queue is drained
+	mutex  sync.Mutex
+	closed bool
+}
+
+// startWorkerPool starts the forward's workers, which stop with ctx.
+// Connections still queued then are closed. It returns nil when
+// workerPoolSize is not set.
+func startWorkerPool(ctx context.Context, config *ForwardConfig) *workerPool {
+	if config.WorkerPoolSize == 0 {
+		return nil
+	}
+	pool := &workerPool{
+		jobs:    make(chan workerJob, config.WorkerQueueSize),
+		drop:    config.WorkerOverflow == overflowDrop,
+		section: config.SectionName,
+	}
+	for i := 0; i < config.WorkerPoolSize; i++ {
+		go func() {
+			for {
+				select {
+				case job := <-pool.jobs:
+					job.handle()
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	context.AfterFunc(ctx, func() {
+		pool.mutex.Lock()
+		defer pool.mutex.Unlock()
+		pool.closed = true
+		for {
+			select {
+			case job := <-pool.jobs:
+				job.conn.Close()
+			default:
+				return
+			}
+		}
+	})
+	return pool
+}
+
+// run handles conn on a worker, or on a goroutine of its own without a pool.
+// A full queue makes it wait, or close conn with workerOverflow=drop. Once
+// ctx is done and the queue drained, conn is closed instead.
+func (p *workerPool) run(ctx context.Context, conn net.Conn, handle func()) {
+	if p == nil {
+		go handle()
+		return
+	}
+	// A wait for room ends with ctx, which the drain waits for
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.closed {
+		conn.Close()
+		return
+	}
+	job := workerJob{conn, handle}
+	if p.drop {
+		select {
+		case p.jobs <- job:
+		default:
+			log.Printf("Worker queue of %s is full, dropping connection from %s", p.section, conn.RemoteAddr())
+			conn.Close()
+		}
+		return
+	}
+	select {
+	case p.jobs <- job:
+	case <-ctx.Done():
+		conn.Close()
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+// Connections handed to a block-mode pool after its forward stopped must
+// still be closed, whether or not the queue was drained already.
+func TestWorkerPoolClosesConnsAfterCancel(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		ctx, cancel := context.WithCancel(context.Background())
+		pool := startWorkerPool(ctx, &ForwardConfig{
+			SectionName:     "test",
+			WorkerPoolSize:  1,
+			WorkerQueueSize: 8,
+			WorkerOverflow:  overflowBlock,
+		})
+		cancel()
+		if i%2 == 0 {
+			// Give the drain a chance to run first
+			time.Sleep(time.Millisecond)
+		}
+
+		client, server := net.Pipe()
+		pool.run(ctx, server, func() { server.Close() })
+
+		client.SetReadDeadline(time.Now().Add(2 * time.Second))
+		if _, err := client.Read(make([]byte, 1)); err == nil || isTimeout(err) {
+			t.Fatalf("iteration %d: connection left open after cancel (%v)", i, err)
+		}
+		client.Close()
+	}
+}
+
+func isTimeout(err error) bool {
+	netErr, ok := err.(net.Error)
+	return ok && netErr.Timeout()
+}